@@ -0,0 +1,5 @@
+module github.com/YHVCorp/signer-service/authenticode
+
+go 1.23.0
+
+toolchain go1.24.2
@@ -0,0 +1,203 @@
+// Package authenticode reads PE files and the Authenticode signatures
+// embedded in them without relying on Windows APIs, so the server and the
+// signing clients can inspect binaries on any OS.
+package authenticode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+const (
+	peMagic32     = 0x10b
+	peMagic64     = 0x20b
+	securityIndex = 4
+
+	// WinCertTypePKCSSignedData is the WIN_CERTIFICATE type of an
+	// Authenticode signature.
+	WinCertTypePKCSSignedData = 0x0002
+)
+
+var ErrNotPE = errors.New("not a PE file")
+
+// File is a parsed PE file. Offsets are file offsets.
+type File struct {
+	r    io.ReaderAt
+	size int64
+
+	Machine   uint16
+	Subsystem uint16
+	// Checksum is the image checksum stored in the optional header.
+	Checksum uint32
+	Is64     bool
+
+	checksumOffset    int64
+	securityDirOffset int64 // -1 when the image has no security directory
+
+	CertTableOffset int64
+	CertTableSize   int64
+}
+
+// WinCertificate is an entry of the attribute certificate table.
+type WinCertificate struct {
+	Revision uint16
+	Type     uint16
+	Data     []byte
+}
+
+// Parse reads the headers of the PE file in r.
+func Parse(r io.ReaderAt, size int64) (*File, error) {
+	var dos [64]byte
+	if _, err := r.ReadAt(dos[:], 0); err != nil {
+		return nil, ErrNotPE
+	}
+	if dos[0] != 'M' || dos[1] != 'Z' {
+		return nil, ErrNotPE
+	}
+
+	peOffset := int64(binary.LittleEndian.Uint32(dos[0x3c:]))
+	var coff [24]byte
+	if _, err := r.ReadAt(coff[:], peOffset); err != nil {
+		return nil, ErrNotPE
+	}
+	if string(coff[:4]) != "PE\x00\x00" {
+		return nil, ErrNotPE
+	}
+	optionalSize := int64(binary.LittleEndian.Uint16(coff[20:]))
+
+	optionalOffset := peOffset + 24
+	var optional [72]byte
+	if _, err := r.ReadAt(optional[:], optionalOffset); err != nil {
+		return nil, fmt.Errorf("truncated optional header: %v", err)
+	}
+
+	f := &File{
+		r:                 r,
+		size:              size,
+		Machine:           binary.LittleEndian.Uint16(coff[4:]),
+		Checksum:          binary.LittleEndian.Uint32(optional[64:]),
+		Subsystem:         binary.LittleEndian.Uint16(optional[68:]),
+		checksumOffset:    optionalOffset + 64,
+		securityDirOffset: -1,
+	}
+
+	var rvaCountOffset int64
+	magic := binary.LittleEndian.Uint16(optional[:])
+	switch magic {
+	case peMagic32:
+		rvaCountOffset = optionalOffset + 92
+	case peMagic64:
+		f.Is64 = true
+		rvaCountOffset = optionalOffset + 108
+	default:
+		return nil, fmt.Errorf("unknown optional header magic %#x", magic)
+	}
+
+	var count [4]byte
+	if _, err := r.ReadAt(count[:], rvaCountOffset); err != nil {
+		return nil, fmt.Errorf("truncated optional header: %v", err)
+	}
+	dirOffset := rvaCountOffset + 4 + securityIndex*8
+	if binary.LittleEndian.Uint32(count[:]) <= securityIndex || dirOffset+8 > optionalOffset+optionalSize {
+		return f, nil
+	}
+	f.securityDirOffset = dirOffset
+
+	var dir [8]byte
+	if _, err := r.ReadAt(dir[:], dirOffset); err != nil {
+		return nil, fmt.Errorf("truncated data directories: %v", err)
+	}
+	f.CertTableOffset = int64(binary.LittleEndian.Uint32(dir[:4]))
+	f.CertTableSize = int64(binary.LittleEndian.Uint32(dir[4:]))
+
+	if f.CertTableSize > 0 {
+		if f.CertTableOffset < dirOffset+8 || f.CertTableOffset+f.CertTableSize > size {
+			return nil, fmt.Errorf("certificate table at %d (%d bytes) is outside the file", f.CertTableOffset, f.CertTableSize)
+		}
+	}
+
+	return f, nil
+}
+
+// Signed reports whether the file carries an attribute certificate table.
+func (f *File) Signed() bool {
+	return f.CertTableSize > 0
+}
+
+// Size returns the size of the file.
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// ContentReader returns the bytes covered by the Authenticode digest: the
+// whole file except the checksum, the security directory entry and the
+// certificate table.
+func (f *File) ContentReader() io.Reader {
+	end := f.size
+	if f.Signed() {
+		end = f.CertTableOffset
+	}
+
+	var readers []io.Reader
+	section := func(from, to int64) {
+		if to > from {
+			readers = append(readers, io.NewSectionReader(f.r, from, to-from))
+		}
+	}
+
+	if f.securityDirOffset < 0 {
+		section(0, f.checksumOffset)
+		section(f.checksumOffset+4, end)
+	} else {
+		section(0, f.checksumOffset)
+		section(f.checksumOffset+4, f.securityDirOffset)
+		section(f.securityDirOffset+8, end)
+	}
+
+	return io.MultiReader(readers...)
+}
+
+// Digest computes the Authenticode digest of the file with h.
+func (f *File) Digest(h hash.Hash) ([]byte, error) {
+	if _, err := io.Copy(h, f.ContentReader()); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Certificates returns the entries of the attribute certificate table.
+func (f *File) Certificates() ([]WinCertificate, error) {
+	if !f.Signed() {
+		return nil, nil
+	}
+
+	table := make([]byte, f.CertTableSize)
+	if _, err := f.r.ReadAt(table, f.CertTableOffset); err != nil {
+		return nil, fmt.Errorf("error reading certificate table: %v", err)
+	}
+
+	var certs []WinCertificate
+	for len(table) >= 8 {
+		length := int(binary.LittleEndian.Uint32(table))
+		if length < 8 || length > len(table) {
+			return nil, fmt.Errorf("invalid WIN_CERTIFICATE length %d", length)
+		}
+		certs = append(certs, WinCertificate{
+			Revision: binary.LittleEndian.Uint16(table[4:]),
+			Type:     binary.LittleEndian.Uint16(table[6:]),
+			Data:     table[8:length],
+		})
+
+		// Entries are aligned on 8 bytes
+		next := (length + 7) &^ 7
+		if next > len(table) {
+			break
+		}
+		table = table[next:]
+	}
+
+	return certs, nil
+}
@@ -0,0 +1,172 @@
+package authenticode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The fixtures are written by testdata/generate.go. The digest and the
+// thumbprint were computed with other tools from the fixture bytes.
+const (
+	fixtureDigest     = "d14f98abb5072becc92e1539c8f254946ef6ba1029cbc0709cb2145a9390397e"
+	fixtureThumbprint = "4856BD60E4C36E7E1138F9B093BC9DC15882B3CA"
+
+	fixtureSecurityDir = 0x40 + 24 + 112 + securityIndex*8
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func parseBytes(t *testing.T, data []byte) *File {
+	t.Helper()
+	f, err := Parse(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Parse() = %v", err)
+	}
+	return f
+}
+
+// patch returns a copy of data with value written at offset.
+func patch(data []byte, offset int, value ...byte) []byte {
+	patched := append([]byte(nil), data...)
+	copy(patched[offset:], value)
+	return patched
+}
+
+func le32(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+func TestParse(t *testing.T) {
+	unsigned := readFixture(t, "unsigned.exe")
+	signed := readFixture(t, "signed.exe")
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantErr    error
+		signed     bool
+		tableSize  int64
+		securityOK bool
+	}{
+		{name: "unsigned", data: unsigned, securityOK: true},
+		{name: "signed", data: signed, signed: true, tableSize: 1456, securityOK: true},
+		{name: "text file", data: []byte("#!/bin/sh\necho not a binary\n"), wantErr: ErrNotPE},
+		{name: "empty", data: nil, wantErr: ErrNotPE},
+		{name: "dos header only", data: unsigned[:0x40], wantErr: ErrNotPE},
+		{name: "missing PE signature", data: patch(unsigned, 0x40, 'N', 'E'), wantErr: ErrNotPE},
+		// Four data directories stop before the security directory
+		{name: "no security directory", data: patch(unsigned, 0x40+24+108, 4)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Parse() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if !f.Is64 || f.Machine != 0x8664 || f.Subsystem != 3 {
+				t.Errorf("Is64 = %v, Machine = %#x, Subsystem = %d, want a PE32+ AMD64 console image", f.Is64, f.Machine, f.Subsystem)
+			}
+			if f.Signed() != tt.signed || f.CertTableSize != tt.tableSize {
+				t.Errorf("Signed() = %v with a %d byte table, want %v with %d", f.Signed(), f.CertTableSize, tt.signed, tt.tableSize)
+			}
+			if tt.signed && f.CertTableOffset != int64(len(unsigned)) {
+				t.Errorf("CertTableOffset = %d, want %d", f.CertTableOffset, len(unsigned))
+			}
+			if got := f.securityDirOffset >= 0; got != tt.securityOK {
+				t.Errorf("security directory found = %v, want %v", got, tt.securityOK)
+			}
+		})
+	}
+}
+
+func TestParseRejectsCertificateTableOutsideFile(t *testing.T) {
+	signed := readFixture(t, "signed.exe")
+
+	tests := []struct {
+		name   string
+		offset uint32
+		size   uint32
+	}{
+		{"past the end", 1024, 4096},
+		{"inside the headers", 0x40, 1456},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := patch(signed, fixtureSecurityDir, append(le32(tt.offset), le32(tt.size)...)...)
+			if _, err := Parse(bytes.NewReader(data), int64(len(data))); err == nil {
+				t.Fatal("Parse() accepted a certificate table outside the file")
+			}
+		})
+	}
+}
+
+func TestDigest(t *testing.T) {
+	unsigned := readFixture(t, "unsigned.exe")
+	signed := readFixture(t, "signed.exe")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		matches bool
+	}{
+		{"unsigned", unsigned, true},
+		// The checksum, the security directory and the table are skipped
+		{"signed", signed, true},
+		{"other checksum", patch(unsigned, 0x40+24+64, 0xde, 0xad, 0xbe, 0xef), true},
+		{"modified code", patch(unsigned, 0x200, 0x90), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			digest, err := parseBytes(t, tt.data).Digest(sha256.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := hex.EncodeToString(digest)
+			if (got == fixtureDigest) != tt.matches {
+				t.Errorf("Digest() = %s, matches the fixture digest %v, want %v", got, !tt.matches, tt.matches)
+			}
+		})
+	}
+}
+
+func TestCertificates(t *testing.T) {
+	certs, err := parseBytes(t, readFixture(t, "unsigned.exe")).Certificates()
+	if err != nil || certs != nil {
+		t.Fatalf("Certificates() of an unsigned file = %v, %v", certs, err)
+	}
+
+	certs, err = parseBytes(t, readFixture(t, "signed.exe")).Certificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("found %d certificate table entries, want 1", len(certs))
+	}
+	if certs[0].Revision != 0x0200 || certs[0].Type != WinCertTypePKCSSignedData {
+		t.Errorf("entry revision %#x type %#x, want a PKCS#7 WIN_CERT_REVISION_2_0 entry", certs[0].Revision, certs[0].Type)
+	}
+	// The entry is padded to 8 bytes after the DER signature
+	if len(certs[0].Data) != 1456-8 {
+		t.Errorf("entry holds %d bytes, want %d", len(certs[0].Data), 1456-8)
+	}
+}
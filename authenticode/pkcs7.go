@@ -0,0 +1,484 @@
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSpcIndirectData      = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidCounterSignature     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+	oidRFC3161Timestamp     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+	oidTSTInfo              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidNestedSignature      = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 4, 1}
+	oidDigestSHA1           = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512         = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	errNoSigner             = errors.New("signer certificate not found")
+	errUnsupportedKeyType   = errors.New("unsupported signer key type")
+	errMissingMessageDigest = errors.New("missing message digest attribute")
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          asn1.RawValue
+	MessageDigest digestInfo
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint digestInfo
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+// Signature is a decoded Authenticode signature.
+type Signature struct {
+	// DigestAlgorithm is the hash used for the image digest.
+	DigestAlgorithm crypto.Hash
+	// Digest is the image digest the signature covers.
+	Digest []byte
+	// Signer is the certificate that produced the signature.
+	Signer *x509.Certificate
+	// Certificates holds every certificate embedded in the signature.
+	Certificates []*x509.Certificate
+	// Timestamp is nil when the signature has no countersignature.
+	Timestamp *Timestamp
+	// Nested holds the additional signatures appended by dual signing, for
+	// example a SHA-256 signature next to a SHA-1 one.
+	Nested []*Signature
+
+	signerInfo signerInfo
+	content    []byte
+}
+
+// Timestamp is a countersignature proving when the file was signed.
+type Timestamp struct {
+	Time    time.Time
+	Signer  *x509.Certificate
+	RFC3161 bool
+}
+
+// ParseSignature decodes the PKCS#7 SignedData of a WIN_CERTIFICATE.
+func ParseSignature(der []byte) (*Signature, error) {
+	sd, certs, err := parseSignedData(der)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectData) {
+		return nil, fmt.Errorf("unexpected content type %v", sd.ContentInfo.ContentType)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("expected one signer, found %d", len(sd.SignerInfos))
+	}
+
+	// The signed content is the SpcIndirectDataContent without its own tag
+	// and length
+	var content asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("error decoding indirect data: %v", err)
+	}
+	var indirect spcIndirectDataContent
+	if _, err := asn1.Unmarshal(content.FullBytes, &indirect); err != nil {
+		return nil, fmt.Errorf("error decoding indirect data: %v", err)
+	}
+
+	digestHash, err := hashForOID(indirect.MessageDigest.Algorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	si := sd.SignerInfos[0]
+	sig := &Signature{
+		DigestAlgorithm: digestHash,
+		Digest:          indirect.MessageDigest.Digest,
+		Signer:          findSigner(si, certs),
+		Certificates:    certs,
+		signerInfo:      si,
+		content:         content.Bytes,
+	}
+
+	sig.Timestamp, err = parseTimestamp(si, certs)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding timestamp: %v", err)
+	}
+
+	sig.Nested, err = parseNestedSignatures(si)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding nested signature: %v", err)
+	}
+
+	return sig, nil
+}
+
+// Chain returns the signer certificate followed by the issuers found among
+// the embedded certificates, up to a self-signed root or the last issuer
+// available.
+func (s *Signature) Chain() []*x509.Certificate {
+	if s.Signer == nil {
+		return nil
+	}
+
+	chain := []*x509.Certificate{s.Signer}
+	for current := s.Signer; len(chain) <= len(s.Certificates); {
+		if bytes.Equal(current.RawIssuer, current.RawSubject) {
+			break
+		}
+
+		var issuer *x509.Certificate
+		for _, cert := range s.Certificates {
+			if bytes.Equal(cert.RawSubject, current.RawIssuer) && current.CheckSignatureFrom(cert) == nil {
+				issuer = cert
+				break
+			}
+		}
+		if issuer == nil {
+			break
+		}
+
+		chain = append(chain, issuer)
+		current = issuer
+	}
+
+	return chain
+}
+
+// Verify checks that the signer certificate signed the image digest. It does
+// not check the certificate chain.
+func (s *Signature) Verify() error {
+	_, err := verifySignerInfo(s.signerInfo, s.content, s.Certificates)
+	return err
+}
+
+// Thumbprint returns the SHA-1 thumbprint of the signer certificate, as shown
+// by Windows.
+func (s *Signature) Thumbprint() string {
+	if s.Signer == nil {
+		return ""
+	}
+	return Thumbprint(s.Signer)
+}
+
+// Thumbprint returns the SHA-1 thumbprint of the certificate in upper case
+// hex.
+func Thumbprint(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func parseSignedData(der []byte) (*signedData, []*x509.Certificate, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, nil, fmt.Errorf("error decoding content info: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, fmt.Errorf("unexpected content type %v", ci.ContentType)
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, nil, fmt.Errorf("error decoding signed data: %v", err)
+	}
+
+	return &sd, parseCertificates(sd.Certificates.Bytes), nil
+}
+
+// parseCertificates decodes the certificate set, skipping entries that are
+// not X.509 certificates.
+func parseCertificates(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for len(data) > 0 {
+		var raw asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &raw)
+		if err != nil {
+			break
+		}
+		if cert, err := x509.ParseCertificate(raw.FullBytes); err == nil {
+			certs = append(certs, cert)
+		}
+		data = rest
+	}
+	return certs
+}
+
+func parseAttributes(raw asn1.RawValue) ([]attribute, error) {
+	var attrs []attribute
+	data := raw.Bytes
+	for len(data) > 0 {
+		var attr attribute
+		rest, err := asn1.Unmarshal(data, &attr)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+		data = rest
+	}
+	return attrs, nil
+}
+
+func findAttribute(attrs []attribute, oid asn1.ObjectIdentifier) []byte {
+	for _, attr := range attrs {
+		if attr.Type.Equal(oid) {
+			return attr.Values.Bytes
+		}
+	}
+	return nil
+}
+
+// parseNestedSignatures decodes the signatures stored in the unauthenticated
+// attributes of si.
+func parseNestedSignatures(si signerInfo) ([]*Signature, error) {
+	if len(si.UnauthenticatedAttributes.FullBytes) == 0 {
+		return nil, nil
+	}
+
+	attrs, err := parseAttributes(si.UnauthenticatedAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	var nested []*Signature
+	for _, attr := range attrs {
+		if !attr.Type.Equal(oidNestedSignature) {
+			continue
+		}
+
+		values := attr.Values.Bytes
+		for len(values) > 0 {
+			var value asn1.RawValue
+			rest, err := asn1.Unmarshal(values, &value)
+			if err != nil {
+				return nil, err
+			}
+			sig, err := ParseSignature(value.FullBytes)
+			if err != nil {
+				return nil, err
+			}
+			nested = append(nested, sig)
+			values = rest
+		}
+	}
+
+	return nested, nil
+}
+
+func findSigner(si signerInfo, certs []*x509.Certificate) *x509.Certificate {
+	for _, cert := range certs {
+		if cert.SerialNumber.Cmp(si.IssuerAndSerialNumber.SerialNumber) == 0 &&
+			bytes.Equal(cert.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) {
+			return cert
+		}
+	}
+	return nil
+}
+
+// verifySignerInfo checks the signature of si over content and returns the
+// certificate that made it.
+func verifySignerInfo(si signerInfo, content []byte, certs []*x509.Certificate) (*x509.Certificate, error) {
+	signer := findSigner(si, certs)
+	if signer == nil {
+		return nil, errNoSigner
+	}
+
+	h, err := hashForOID(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := content
+	if len(si.AuthenticatedAttributes.FullBytes) > 0 {
+		attrs, err := parseAttributes(si.AuthenticatedAttributes)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding authenticated attributes: %v", err)
+		}
+
+		value := findAttribute(attrs, oidMessageDigest)
+		if value == nil {
+			return nil, errMissingMessageDigest
+		}
+		var messageDigest []byte
+		if _, err := asn1.Unmarshal(value, &messageDigest); err != nil {
+			return nil, fmt.Errorf("error decoding message digest: %v", err)
+		}
+
+		hasher := h.New()
+		hasher.Write(content)
+		if !bytes.Equal(hasher.Sum(nil), messageDigest) {
+			return nil, errors.New("message digest does not match the signed content")
+		}
+
+		// The attributes are signed as a SET, not with their implicit tag
+		signed = append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+	}
+
+	hasher := h.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch pub := signer.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, h, digest, si.EncryptedDigest)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, si.EncryptedDigest) {
+			err = errors.New("ecdsa verification failure")
+		}
+	default:
+		return nil, errUnsupportedKeyType
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %v", err)
+	}
+
+	return signer, nil
+}
+
+// parseTimestamp reads the RFC 3161 timestamp or the legacy countersignature
+// of the signer, verifying that it covers the signature.
+func parseTimestamp(si signerInfo, certs []*x509.Certificate) (*Timestamp, error) {
+	if len(si.UnauthenticatedAttributes.FullBytes) == 0 {
+		return nil, nil
+	}
+
+	attrs, err := parseAttributes(si.UnauthenticatedAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	if value := findAttribute(attrs, oidRFC3161Timestamp); value != nil {
+		tsd, tsCerts, err := parseSignedData(value)
+		if err != nil {
+			return nil, err
+		}
+		if !tsd.ContentInfo.ContentType.Equal(oidTSTInfo) || len(tsd.SignerInfos) != 1 {
+			return nil, errors.New("unexpected timestamp token content")
+		}
+
+		var tstDER []byte
+		if _, err := asn1.Unmarshal(tsd.ContentInfo.Content.Bytes, &tstDER); err != nil {
+			return nil, err
+		}
+		var info tstInfo
+		if _, err := asn1.Unmarshal(tstDER, &info); err != nil {
+			return nil, err
+		}
+
+		if err := checkImprint(info.MessageImprint, si.EncryptedDigest); err != nil {
+			return nil, err
+		}
+		signer, err := verifySignerInfo(tsd.SignerInfos[0], tstDER, tsCerts)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Timestamp{Time: info.GenTime, Signer: signer, RFC3161: true}, nil
+	}
+
+	if value := findAttribute(attrs, oidCounterSignature); value != nil {
+		var counter signerInfo
+		if _, err := asn1.Unmarshal(value, &counter); err != nil {
+			return nil, err
+		}
+		signer, err := verifySignerInfo(counter, si.EncryptedDigest, certs)
+		if err != nil {
+			return nil, err
+		}
+
+		ts := &Timestamp{Signer: signer}
+		counterAttrs, err := parseAttributes(counter.AuthenticatedAttributes)
+		if err != nil {
+			return nil, err
+		}
+		if value := findAttribute(counterAttrs, oidSigningTime); value != nil {
+			asn1.Unmarshal(value, &ts.Time)
+		}
+
+		return ts, nil
+	}
+
+	return nil, nil
+}
+
+func checkImprint(imprint digestInfo, signature []byte) error {
+	h, err := hashForOID(imprint.Algorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	hasher := h.New()
+	hasher.Write(signature)
+	if !bytes.Equal(hasher.Sum(nil), imprint.Digest) {
+		return errors.New("timestamp does not cover the signature")
+	}
+	return nil
+}
+
+func hashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidDigestSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidDigestSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidDigestSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %v", oid)
+}
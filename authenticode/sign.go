@@ -0,0 +1,325 @@
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+	"unicode/utf16"
+)
+
+var (
+	oidContentType        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidSpcStatementType   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 11}
+	oidSpcSpOpusInfo      = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 12}
+	oidSpcPEImageData     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}
+	oidIndividualCodeSign = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 21}
+	oidRSAEncryption      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256    = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384    = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512    = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+
+	// spcPEImageData is the SpcPeImageData written by signtool: no flags and
+	// the "<<<Obsolete>>>" file link, which Windows expects.
+	spcPEImageData = []byte{
+		0x30, 0x25, 0x03, 0x01, 0x00, 0xa0, 0x20, 0xa2, 0x1e, 0x80, 0x1c,
+		0x00, 0x3c, 0x00, 0x3c, 0x00, 0x3c, 0x00, 0x4f, 0x00, 0x62, 0x00, 0x73, 0x00,
+		0x6f, 0x00, 0x6c, 0x00, 0x65, 0x00, 0x74, 0x00, 0x65, 0x00, 0x3e, 0x00, 0x3e,
+		0x00, 0x3e,
+	}
+
+	// ErrNoSecurityDirectory is returned when signing an image whose optional
+	// header has no room for the certificate table entry.
+	ErrNoSecurityDirectory = errors.New("image has no security directory")
+)
+
+type spcAttributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type spcIndirectDataContentOut struct {
+	Data          spcAttributeTypeAndValue
+	MessageDigest digestInfo
+}
+
+// SignOptions controls how a signature is built.
+type SignOptions struct {
+	// Hash is the digest algorithm, SHA-256 when zero.
+	Hash crypto.Hash
+	// SigningTime is recorded in the signed attributes. The current time is
+	// used when zero; a fixed time makes the signature reproducible for keys
+	// with deterministic signatures.
+	SigningTime time.Time
+	// Timestamp, when set, receives the signature value and returns the DER
+	// RFC 3161 timestamp token to embed, see RequestTimestamp.
+	Timestamp func(signature []byte) ([]byte, error)
+	// Description and URL are shown by Windows in the elevation prompt and
+	// the signature details, like the /d and /du options of signtool.
+	Description string
+	URL         string
+}
+
+// Sign builds the Authenticode signature of the image with key, whose
+// certificate must be the first of chain. The result is the PKCS#7
+// SignedData to embed with WriteSigned; an existing signature of the image
+// is replaced, not nested.
+func (f *File) Sign(key crypto.Signer, chain []*x509.Certificate, opts *SignOptions) ([]byte, error) {
+	if f.securityDirOffset < 0 {
+		return nil, ErrNoSecurityDirectory
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("missing signer certificate")
+	}
+	if opts == nil {
+		opts = &SignOptions{}
+	}
+
+	h := opts.Hash
+	if h == 0 {
+		h = crypto.SHA256
+	}
+	digestAlgorithm, err := digestAlgorithmFor(h)
+	if err != nil {
+		return nil, err
+	}
+	signatureAlgorithm, err := signatureAlgorithmFor(key.Public(), h)
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := h.New()
+	if _, err := io.Copy(hasher, f.signedContentReader()); err != nil {
+		return nil, fmt.Errorf("error computing image digest: %v", err)
+	}
+
+	content, err := asn1.Marshal(spcIndirectDataContentOut{
+		Data: spcAttributeTypeAndValue{
+			Type:  oidSpcPEImageData,
+			Value: asn1.RawValue{FullBytes: spcPEImageData},
+		},
+		MessageDigest: digestInfo{Algorithm: digestAlgorithm, Digest: hasher.Sum(nil)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	signingTime := opts.SigningTime
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+	opusInfo, err := spcSpOpusInfo(opts.Description, opts.URL)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := signedAttributes(h, content, opusInfo, signingTime.UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	// The attributes are signed as a SET, like verifySignerInfo checks them
+	hasher = h.New()
+	hasher.Write(append([]byte{0x31}, attrs.FullBytes[1:]...))
+	signature, err := key.Sign(rand.Reader, hasher.Sum(nil), h)
+	if err != nil {
+		return nil, fmt.Errorf("error signing: %v", err)
+	}
+
+	si := signerInfo{
+		Version: 1,
+		IssuerAndSerialNumber: issuerAndSerial{
+			Issuer:       asn1.RawValue{FullBytes: chain[0].RawIssuer},
+			SerialNumber: chain[0].SerialNumber,
+		},
+		DigestAlgorithm:           digestAlgorithm,
+		AuthenticatedAttributes:   attrs,
+		DigestEncryptionAlgorithm: signatureAlgorithm,
+		EncryptedDigest:           signature,
+	}
+
+	if opts.Timestamp != nil {
+		token, err := opts.Timestamp(signature)
+		if err != nil {
+			return nil, fmt.Errorf("error timestamping: %v", err)
+		}
+		attr, err := rawAttribute(oidRFC3161Timestamp, token)
+		if err != nil {
+			return nil, err
+		}
+		si.UnauthenticatedAttributes, err = attributeSet(asn1.ClassContextSpecific, 1, attr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var certs []byte
+	for _, cert := range chain {
+		certs = append(certs, cert.Raw...)
+	}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithm},
+		ContentInfo: contentInfo{
+			ContentType: oidSpcIndirectData,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos:  []signerInfo{si},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// signedAttributes returns the authenticated attributes Windows expects on
+// an Authenticode signature, as the implicitly tagged SET of the SignerInfo.
+func signedAttributes(h crypto.Hash, content, opusInfo []byte, signingTime time.Time) (asn1.RawValue, error) {
+	var indirect asn1.RawValue
+	if _, err := asn1.Unmarshal(content, &indirect); err != nil {
+		return asn1.RawValue{}, err
+	}
+	hasher := h.New()
+	hasher.Write(indirect.Bytes)
+
+	contentType, err := newAttribute(oidContentType, oidSpcIndirectData)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	statementType, err := newAttribute(oidSpcStatementType, []asn1.ObjectIdentifier{oidIndividualCodeSign})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	opusInfoAttr, err := rawAttribute(oidSpcSpOpusInfo, opusInfo)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	signingTimeAttr, err := newAttribute(oidSigningTime, signingTime)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	messageDigest, err := newAttribute(oidMessageDigest, hasher.Sum(nil))
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	return attributeSet(asn1.ClassContextSpecific, 0, contentType, statementType, opusInfoAttr, signingTimeAttr, messageDigest)
+}
+
+// spcSpOpusInfo encodes the SpcSpOpusInfo attribute value: the program name
+// as an SpcString in UTF-16 and the more info link as an SpcLink URL, both
+// explicitly tagged and optional.
+func spcSpOpusInfo(description, url string) ([]byte, error) {
+	var fields []byte
+	if description != "" {
+		var name []byte
+		for _, r := range utf16.Encode([]rune(description)) {
+			name = append(name, byte(r>>8), byte(r))
+		}
+		field, err := explicit(0, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: name})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field...)
+	}
+	if url != "" {
+		field, err := explicit(1, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte(url)})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field...)
+	}
+
+	return asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: fields})
+}
+
+// explicit encodes value wrapped in an explicit context specific tag.
+func explicit(tag int, value asn1.RawValue) ([]byte, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: der})
+}
+
+// newAttribute encodes an attribute with a single value.
+func newAttribute(oid asn1.ObjectIdentifier, value interface{}) ([]byte, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return rawAttribute(oid, der)
+}
+
+// rawAttribute encodes an attribute with a single DER encoded value.
+func rawAttribute(oid asn1.ObjectIdentifier, der []byte) ([]byte, error) {
+	return asn1.Marshal(attribute{
+		Type:   oid,
+		Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der},
+	})
+}
+
+// attributeSet encodes attributes as an implicitly tagged SET OF, sorted as
+// DER requires.
+func attributeSet(class, tag int, attrs ...[]byte) (asn1.RawValue, error) {
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+
+	raw := asn1.RawValue{Class: class, Tag: tag, IsCompound: true, Bytes: bytes.Join(attrs, nil)}
+	der, err := asn1.Marshal(raw)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	raw.FullBytes = der
+	return raw, nil
+}
+
+func digestAlgorithmFor(h crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	var oid asn1.ObjectIdentifier
+	switch h {
+	case crypto.SHA1:
+		oid = oidDigestSHA1
+	case crypto.SHA256:
+		oid = oidDigestSHA256
+	case crypto.SHA384:
+		oid = oidDigestSHA384
+	case crypto.SHA512:
+		oid = oidDigestSHA512
+	default:
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported digest algorithm %v", h)
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue}, nil
+}
+
+func signatureAlgorithmFor(pub crypto.PublicKey, h crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}, nil
+	case *ecdsa.PublicKey:
+		switch h {
+		case crypto.SHA256:
+			return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+		case crypto.SHA384:
+			return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA384}, nil
+		case crypto.SHA512:
+			return pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA512}, nil
+		}
+		return pkix.AlgorithmIdentifier{}, fmt.Errorf("unsupported digest algorithm %v for ECDSA", h)
+	}
+	return pkix.AlgorithmIdentifier{}, errUnsupportedKeyType
+}
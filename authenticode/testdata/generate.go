@@ -0,0 +1,127 @@
+//go:build ignore
+
+// generate writes the PE fixtures of the package tests: a minimal PE32+
+// console program that returns 0 and a copy of it signed with a throwaway
+// self-signed certificate. Run it from the package directory with
+//
+//	go run testdata/generate.go
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+)
+
+func main() {
+	unsigned := minimalPE()
+	if err := os.WriteFile("testdata/unsigned.exe", unsigned, 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Signer Service Test"},
+		NotBefore:    time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2036, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := authenticode.Parse(bytes.NewReader(unsigned), int64(len(unsigned)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	signature, err := f.Sign(key, []*x509.Certificate{cert}, &authenticode.SignOptions{
+		SigningTime: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+		Description: "Signer Service Test",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var signed bytes.Buffer
+	if err := f.WriteSigned(&signed, signature); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("testdata/signed.exe", signed.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// minimalPE returns a PE32+ image with a single .text section holding
+// "xor eax, eax; ret".
+func minimalPE() []byte {
+	const (
+		peOffset       = 0x40
+		optionalOffset = peOffset + 24
+		optionalSize   = 240
+		sectionOffset  = optionalOffset + optionalSize
+		fileAlignment  = 0x200
+	)
+	image := make([]byte, 2*fileAlignment)
+	le := binary.LittleEndian
+
+	copy(image, "MZ")
+	le.PutUint32(image[0x3c:], peOffset)
+
+	copy(image[peOffset:], "PE\x00\x00")
+	coff := image[peOffset+4:]
+	le.PutUint16(coff[0:], 0x8664) // AMD64
+	le.PutUint16(coff[2:], 1)      // NumberOfSections
+	le.PutUint16(coff[16:], optionalSize)
+	le.PutUint16(coff[18:], 0x22) // executable, large address aware
+
+	optional := image[optionalOffset:]
+	le.PutUint16(optional[0:], 0x20b)
+	le.PutUint32(optional[4:], fileAlignment) // SizeOfCode
+	le.PutUint32(optional[16:], 0x1000)       // AddressOfEntryPoint
+	le.PutUint32(optional[20:], 0x1000)       // BaseOfCode
+	le.PutUint64(optional[24:], 0x140000000)  // ImageBase
+	le.PutUint32(optional[32:], 0x1000)       // SectionAlignment
+	le.PutUint32(optional[36:], fileAlignment)
+	le.PutUint16(optional[40:], 6)             // MajorOperatingSystemVersion
+	le.PutUint16(optional[48:], 6)             // MajorSubsystemVersion
+	le.PutUint32(optional[56:], 0x2000)        // SizeOfImage
+	le.PutUint32(optional[60:], fileAlignment) // SizeOfHeaders
+	le.PutUint16(optional[68:], 3)             // console
+	le.PutUint16(optional[70:], 0x8160)        // ASLR, DEP, high entropy VA, terminal server aware
+	le.PutUint64(optional[72:], 0x100000)      // SizeOfStackReserve
+	le.PutUint64(optional[80:], 0x1000)        // SizeOfStackCommit
+	le.PutUint64(optional[88:], 0x100000)      // SizeOfHeapReserve
+	le.PutUint64(optional[96:], 0x1000)        // SizeOfHeapCommit
+	le.PutUint32(optional[108:], 16)           // NumberOfRvaAndSizes
+
+	section := image[sectionOffset:]
+	copy(section, ".text")
+	le.PutUint32(section[8:], 3)       // VirtualSize
+	le.PutUint32(section[12:], 0x1000) // VirtualAddress
+	le.PutUint32(section[16:], fileAlignment)
+	le.PutUint32(section[20:], fileAlignment) // PointerToRawData
+	le.PutUint32(section[36:], 0x60000020)    // code, execute, read
+
+	copy(image[fileAlignment:], []byte{0x31, 0xc0, 0xc3})
+
+	return image
+}
@@ -0,0 +1,158 @@
+package authenticode
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+)
+
+const (
+	timestampQueryContentType = "application/timestamp-query"
+	timestampReplyContentType = "application/timestamp-reply"
+	maxTimestampReplySize     = 1 << 20
+)
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint digestInfo
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"`
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+// tstInfoNonce is tstInfo with the fields up to the nonce.
+type tstInfoNonce struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint digestInfo
+	SerialNumber   *big.Int
+	GenTime        asn1.RawValue
+	Accuracy       accuracy `asn1:"optional"`
+	Ordering       bool     `asn1:"optional"`
+	Nonce          *big.Int `asn1:"optional"`
+}
+
+// RequestTimestamp asks the RFC 3161 time stamping authority at url for a
+// token covering signature, the signature value of an Authenticode signer,
+// and returns the DER timestamp token for SignOptions.Timestamp. The token
+// is checked against the request before it is returned.
+func RequestTimestamp(ctx context.Context, client *http.Client, url string, h crypto.Hash, signature []byte) ([]byte, error) {
+	algorithm, err := digestAlgorithmFor(h)
+	if err != nil {
+		return nil, err
+	}
+	hasher := h.New()
+	hasher.Write(signature)
+
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := asn1.Marshal(timeStampReq{
+		Version:        1,
+		MessageImprint: digestInfo{Algorithm: algorithm, Digest: hasher.Sum(nil)},
+		Nonce:          nonce,
+		CertReq:        true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", timestampQueryContentType)
+	req.Header.Set("Accept", timestampReplyContentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, maxTimestampReplySize))
+	if err != nil {
+		return nil, err
+	}
+
+	var tsResp timeStampResp
+	if _, err := asn1.Unmarshal(reply, &tsResp); err != nil {
+		return nil, fmt.Errorf("error decoding timestamp reply: %v", err)
+	}
+	// 0 is granted and 1 granted with modifications
+	if tsResp.Status.Status > 1 {
+		return nil, fmt.Errorf("timestamp request rejected with status %d", tsResp.Status.Status)
+	}
+	if len(tsResp.TimeStampToken.FullBytes) == 0 {
+		return nil, errors.New("timestamp reply has no token")
+	}
+
+	token := tsResp.TimeStampToken.FullBytes
+	if err := checkTimestampToken(token, signature, nonce); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// checkTimestampToken verifies that token is signed by the authority and
+// answers the request for signature with nonce.
+func checkTimestampToken(token, signature []byte, nonce *big.Int) error {
+	tsd, tsCerts, err := parseSignedData(token)
+	if err != nil {
+		return err
+	}
+	if !tsd.ContentInfo.ContentType.Equal(oidTSTInfo) || len(tsd.SignerInfos) != 1 {
+		return errors.New("unexpected timestamp token content")
+	}
+
+	var tstDER []byte
+	if _, err := asn1.Unmarshal(tsd.ContentInfo.Content.Bytes, &tstDER); err != nil {
+		return err
+	}
+	var info tstInfoNonce
+	if _, err := asn1.Unmarshal(tstDER, &info); err != nil {
+		return fmt.Errorf("error decoding timestamp info: %v", err)
+	}
+
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return errors.New("timestamp nonce does not match the request")
+	}
+	if err := checkImprint(info.MessageImprint, signature); err != nil {
+		return err
+	}
+	if _, err := verifySignerInfo(tsd.SignerInfos[0], tstDER, tsCerts); err != nil {
+		return fmt.Errorf("invalid timestamp signature: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package authenticode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxSignaturePadding is the number of zero bytes signing tools may add to
+// align the certificate table on 8 bytes.
+const maxSignaturePadding = 7
+
+var ErrNotSigned = errors.New("file is not signed")
+
+// Signature decodes and verifies the primary Authenticode signature of the
+// file: the signer must have signed the image digest and the digest must
+// match the content of the file.
+func (f *File) Signature() (*Signature, error) {
+	certs, err := f.Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cert := range certs {
+		if cert.Type != WinCertTypePKCSSignedData {
+			continue
+		}
+
+		sig, err := ParseSignature(cert.Data)
+		if err != nil {
+			return nil, err
+		}
+		if err := f.verify(sig); err != nil {
+			return nil, err
+		}
+
+		return sig, nil
+	}
+
+	return nil, ErrNotSigned
+}
+
+// Signatures returns the primary signature followed by the nested ones,
+// verifying each against the content of the file.
+func (f *File) Signatures() ([]*Signature, error) {
+	primary, err := f.Signature()
+	if err != nil {
+		return nil, err
+	}
+
+	signatures := []*Signature{primary}
+	for i := 0; i < len(signatures); i++ {
+		for _, nested := range signatures[i].Nested {
+			if err := f.verify(nested); err != nil {
+				return nil, fmt.Errorf("nested signature: %v", err)
+			}
+			signatures = append(signatures, nested)
+		}
+	}
+
+	return signatures, nil
+}
+
+func (f *File) verify(sig *Signature) error {
+	if err := sig.Verify(); err != nil {
+		return err
+	}
+
+	digest, err := f.Digest(sig.DigestAlgorithm.New())
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(digest, sig.Digest) {
+		return errors.New("image digest does not match the signature")
+	}
+
+	return nil
+}
+
+// CompareContent checks that signed is original with a signature added: both
+// files must be byte-identical outside the checksum, the security directory
+// entry and the certificate table, apart from the zero padding added before
+// the table.
+func CompareContent(original, signed *File) error {
+	if signed.Signed() && signed.CertTableOffset+signed.CertTableSize != signed.size {
+		return errors.New("signed file has data after the certificate table")
+	}
+
+	a := bufio.NewReader(original.ContentReader())
+	b := bufio.NewReader(signed.ContentReader())
+
+	var offset int64
+	for {
+		x, errA := a.ReadByte()
+		y, errB := b.ReadByte()
+		if errA == io.EOF {
+			if errB == io.EOF {
+				return nil
+			}
+			return checkPadding(y, b, offset)
+		}
+		if errA != nil {
+			return errA
+		}
+		if errB == io.EOF {
+			return fmt.Errorf("signed file is shorter than the original at content offset %d", offset)
+		}
+		if errB != nil {
+			return errB
+		}
+		if x != y {
+			return fmt.Errorf("signed file differs from the original at content offset %d", offset)
+		}
+		offset++
+	}
+}
+
+func checkPadding(first byte, r *bufio.Reader, offset int64) error {
+	padding := 0
+	for b, err := first, error(nil); err != io.EOF; b, err = r.ReadByte() {
+		if err != nil {
+			return err
+		}
+		padding++
+		if b != 0 || padding > maxSignaturePadding {
+			return fmt.Errorf("signed file has extra content at content offset %d", offset)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package authenticode
+
+import (
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestSignature(t *testing.T) {
+	sig, err := parseBytes(t, readFixture(t, "signed.exe")).Signature()
+	if err != nil {
+		t.Fatalf("Signature() = %v", err)
+	}
+
+	if sig.DigestAlgorithm != crypto.SHA256 || hex.EncodeToString(sig.Digest) != fixtureDigest {
+		t.Errorf("signature covers %v digest %x, want SHA-256 %s", sig.DigestAlgorithm, sig.Digest, fixtureDigest)
+	}
+	if sig.Signer == nil || sig.Signer.Subject.CommonName != "Signer Service Test" {
+		t.Fatalf("Signer = %v, want the fixture certificate", sig.Signer)
+	}
+	if sig.Thumbprint() != fixtureThumbprint || Thumbprint(sig.Signer) != fixtureThumbprint {
+		t.Errorf("Thumbprint() = %s, want %s", sig.Thumbprint(), fixtureThumbprint)
+	}
+	if chain := sig.Chain(); len(chain) != 1 || chain[0] != sig.Signer {
+		t.Errorf("Chain() = %v, want the self-signed signer only", chain)
+	}
+	if sig.Timestamp != nil || len(sig.Nested) != 0 {
+		t.Errorf("Timestamp = %v, %d nested signatures, want none", sig.Timestamp, len(sig.Nested))
+	}
+
+	signatures, err := parseBytes(t, readFixture(t, "signed.exe")).Signatures()
+	if err != nil || len(signatures) != 1 {
+		t.Errorf("Signatures() = %d signatures, %v, want the primary one", len(signatures), err)
+	}
+
+	if _, err := parseBytes(t, readFixture(t, "unsigned.exe")).Signature(); !errors.Is(err, ErrNotSigned) {
+		t.Errorf("Signature() of an unsigned file = %v, want %v", err, ErrNotSigned)
+	}
+}
+
+func TestSignatureTampered(t *testing.T) {
+	signed := readFixture(t, "signed.exe")
+	signature := 1024 + 8
+	flip := func(offset int) []byte {
+		return patch(signed, offset, signed[offset]^0xff)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		// Windows updates the checksum after signing, it is not covered
+		{"checksum", patch(signed, 0x40+24+64, 0, 0, 0, 0), false},
+		{"dos stub", flip(0x10), true},
+		{"section header", flip(0x40 + 24 + 240), true},
+		{"code", flip(0x200), true},
+		{"signature value", flip(signature + 1439), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseBytes(t, tt.data).Signature()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signature() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompareContent(t *testing.T) {
+	unsigned := readFixture(t, "unsigned.exe")
+	signed := readFixture(t, "signed.exe")
+
+	tests := []struct {
+		name     string
+		original []byte
+		signed   []byte
+		wantErr  bool
+	}{
+		{"signed copy", unsigned, signed, false},
+		{"unsigned copy", unsigned, unsigned, false},
+		{"other checksum", unsigned, patch(signed, 0x40+24+64, 1, 2, 3, 4), false},
+		{"tampered code", unsigned, patch(signed, 0x200, 0x90), true},
+		{"tampered header", unsigned, patch(signed, 0x10, 0xff), true},
+		{"truncated", unsigned, unsigned[:1016], true},
+		{"data after the table", unsigned, append(append([]byte(nil), signed...), 0, 0, 0, 0, 0, 0, 0, 0), true},
+		{"extra content", unsigned[:0x200], unsigned, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CompareContent(parseBytes(t, tt.original), parseBytes(t, tt.signed))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CompareContent() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package authenticode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	winCertRevision2 = 0x0200
+	winCertAlignment = 8
+)
+
+// contentEnd returns the offset where the certificate table of the signed
+// image starts: the end of the file, or the current table that signing
+// replaces.
+func (f *File) contentEnd() int64 {
+	if f.Signed() {
+		return f.CertTableOffset
+	}
+	return f.size
+}
+
+// tablePadding returns the zero bytes added before the certificate table,
+// which must start on an 8 byte boundary. They are covered by the digest.
+func (f *File) tablePadding() int64 {
+	return (winCertAlignment - f.contentEnd()%winCertAlignment) % winCertAlignment
+}
+
+// signedContentReader returns the bytes covered by the digest of the image
+// once signed: the content and the padding before the certificate table.
+func (f *File) signedContentReader() io.Reader {
+	return io.MultiReader(f.ContentReader(), bytes.NewReader(make([]byte, f.tablePadding())))
+}
+
+// WriteSigned writes the image to w with signature, as returned by Sign, as
+// its only certificate table entry and an updated checksum. The file is read
+// twice, once to compute the checksum.
+func (f *File) WriteSigned(w io.Writer, signature []byte) error {
+	if f.securityDirOffset < 0 {
+		return ErrNoSecurityDirectory
+	}
+
+	entryLength := int64(8+len(signature)) + (winCertAlignment-int64(8+len(signature))%winCertAlignment)%winCertAlignment
+	tableOffset := f.contentEnd() + f.tablePadding()
+	if tableOffset+entryLength > 1<<32-1 {
+		return fmt.Errorf("signed image would exceed 4 GB")
+	}
+
+	entry := make([]byte, entryLength)
+	binary.LittleEndian.PutUint32(entry[0:], uint32(entryLength))
+	binary.LittleEndian.PutUint16(entry[4:], winCertRevision2)
+	binary.LittleEndian.PutUint16(entry[6:], WinCertTypePKCSSignedData)
+	copy(entry[8:], signature)
+
+	var directory [8]byte
+	binary.LittleEndian.PutUint32(directory[0:], uint32(tableOffset))
+	binary.LittleEndian.PutUint32(directory[4:], uint32(entryLength))
+
+	checksum := &peChecksum{}
+	if _, err := io.Copy(checksum, f.signedImageReader(directory[:], 0, entry)); err != nil {
+		return fmt.Errorf("error computing checksum: %v", err)
+	}
+
+	if _, err := io.Copy(w, f.signedImageReader(directory[:], checksum.Sum(), entry)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// signedImageReader returns the signed image with the given security
+// directory entry, checksum and certificate table.
+func (f *File) signedImageReader(directory []byte, checksum uint32, entry []byte) io.Reader {
+	var value [4]byte
+	binary.LittleEndian.PutUint32(value[:], checksum)
+
+	// The checksum comes before the data directories in the optional header
+	return io.MultiReader(
+		io.NewSectionReader(f.r, 0, f.checksumOffset),
+		bytes.NewReader(value[:]),
+		io.NewSectionReader(f.r, f.checksumOffset+4, f.securityDirOffset-f.checksumOffset-4),
+		bytes.NewReader(directory),
+		io.NewSectionReader(f.r, f.securityDirOffset+8, f.contentEnd()-f.securityDirOffset-8),
+		bytes.NewReader(make([]byte, f.tablePadding())),
+		bytes.NewReader(entry),
+	)
+}
+
+// peChecksum computes the image checksum of the optional header: the 16-bit
+// one's complement sum of the file plus its length. The checksum field must
+// be written as zero.
+type peChecksum struct {
+	sum     uint32
+	size    int64
+	pending []byte
+}
+
+func (c *peChecksum) Write(p []byte) (int, error) {
+	n := len(p)
+	c.size += int64(n)
+
+	if len(c.pending) == 1 && len(p) > 0 {
+		c.add(uint32(c.pending[0]) | uint32(p[0])<<8)
+		c.pending = c.pending[:0]
+		p = p[1:]
+	}
+	for ; len(p) >= 2; p = p[2:] {
+		c.add(uint32(binary.LittleEndian.Uint16(p)))
+	}
+	if len(p) == 1 {
+		c.pending = append(c.pending[:0], p[0])
+	}
+
+	return n, nil
+}
+
+func (c *peChecksum) add(word uint32) {
+	c.sum += word
+	c.sum = (c.sum & 0xffff) + (c.sum >> 16)
+}
+
+// Sum returns the checksum of the bytes written so far.
+func (c *peChecksum) Sum() uint32 {
+	sum := c.sum
+	if len(c.pending) == 1 {
+		sum += uint32(c.pending[0])
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return (sum & 0xffff) + uint32(c.size)
+}
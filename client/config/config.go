@@ -19,14 +19,37 @@ import (
 const (
 	SaltSize       = 16
 	ConfigFileName = "client-config.yaml"
+	DefaultWorkers = 4
 )
 
 type Config struct {
-	Token         string `yaml:"token"`
-	CertPath      string `yaml:"cert_path"`
-	Key           string `yaml:"key"`
-	Container     string `yaml:"container"`
-	ServerAddress string `yaml:"server_address"`
+	Token          string   `yaml:"token"`
+	CertPath       string   `yaml:"cert_path"`
+	Key            string   `yaml:"key"`
+	Container      string   `yaml:"container"`
+	ServerAddress  string   `yaml:"server_address"`
+	Backend        string   `yaml:"backend,omitempty"`
+	CSP            string   `yaml:"csp,omitempty"`
+	Pkcs11Engine   string   `yaml:"pkcs11_engine,omitempty"`
+	Pkcs11Module   string   `yaml:"pkcs11_module,omitempty"`
+	Pkcs11Token    string   `yaml:"pkcs11_token,omitempty"`
+	Pkcs11KeyLabel string   `yaml:"pkcs11_key_label,omitempty"`
+	Pkcs11Pin      string   `yaml:"pkcs11_pin,omitempty"`
+	TimestampURLs  []string `yaml:"timestamp_urls,omitempty"`
+	Digest         string   `yaml:"digest,omitempty"`
+	Description    string   `yaml:"description,omitempty"`
+	DescriptionURL string   `yaml:"description_url,omitempty"`
+	Workers        int      `yaml:"workers,omitempty"`
+	TLS            bool     `yaml:"tls,omitempty"`
+	ServerCA       string   `yaml:"server_ca,omitempty"`
+	ServerName     string   `yaml:"server_name,omitempty"`
+	ClientCert     string   `yaml:"client_cert,omitempty"`
+	ClientKey      string   `yaml:"client_key,omitempty"`
+	HTTPProxy      string   `yaml:"http_proxy,omitempty"`
+	// Servers are encrypted like the server address
+	Servers []ServerEndpoint `yaml:"servers,omitempty"`
+	// Profiles hold their key material encrypted like the main settings
+	Profiles []Profile `yaml:"profiles,omitempty"`
 }
 
 func GetConfigPath() string {
@@ -34,6 +57,11 @@ func GetConfigPath() string {
 }
 
 func encryptValue(value string) (string, error) {
+	// Unset values stay empty, the cipher rejects empty input
+	if value == "" {
+		return "", nil
+	}
+
 	// Generate salt
 	salt := make([]byte, SaltSize)
 	if _, err := rand.Read(salt); err != nil {
@@ -86,40 +114,88 @@ func decryptValue(encryptedValue string) (string, error) {
 	return decrypted, nil
 }
 
-func GenerateConfig(token, certPath, key, container, serverAddress string) error {
+func GenerateConfig(cfg *DecryptedConfig) error {
 	// Encrypt all sensitive values
-	encryptedToken, err := encryptValue(token)
+	encryptedToken, err := encryptValue(cfg.Token)
 	if err != nil {
 		return fmt.Errorf("error encrypting token: %v", err)
 	}
 
-	encryptedCertPath, err := encryptValue(certPath)
+	encryptedCertPath, err := encryptValue(cfg.CertPath)
 	if err != nil {
 		return fmt.Errorf("error encrypting cert path: %v", err)
 	}
 
-	encryptedKey, err := encryptValue(key)
+	encryptedKey, err := encryptValue(cfg.Key)
 	if err != nil {
 		return fmt.Errorf("error encrypting key: %v", err)
 	}
 
-	encryptedContainer, err := encryptValue(container)
+	encryptedContainer, err := encryptValue(cfg.Container)
 	if err != nil {
 		return fmt.Errorf("error encrypting container: %v", err)
 	}
 
-	encryptedServerAddress, err := encryptValue(serverAddress)
+	encryptedServerAddress, err := encryptValue(cfg.ServerAddress)
 	if err != nil {
 		return fmt.Errorf("error encrypting server address: %v", err)
 	}
 
+	encryptedPin, err := encryptValue(cfg.Pkcs11Pin)
+	if err != nil {
+		return fmt.Errorf("error encrypting PKCS#11 PIN: %v", err)
+	}
+
+	encryptedProxy, err := encryptValue(cfg.HTTPProxy)
+	if err != nil {
+		return fmt.Errorf("error encrypting HTTP proxy: %v", err)
+	}
+
+	servers := make([]ServerEndpoint, 0, len(cfg.Servers))
+	for _, s := range cfg.Servers {
+		encrypted, err := encryptServer(s)
+		if err != nil {
+			return fmt.Errorf("server %s: %v", s.GRPC, err)
+		}
+		servers = append(servers, encrypted)
+	}
+
+	profiles := make([]Profile, 0, len(cfg.Profiles))
+	for _, p := range cfg.Profiles {
+		encrypted, err := encryptProfile(p)
+		if err != nil {
+			return fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+		profiles = append(profiles, encrypted)
+	}
+
 	// Save to YAML config file
 	config := Config{
-		Token:         encryptedToken,
-		CertPath:      encryptedCertPath,
-		Key:           encryptedKey,
-		Container:     encryptedContainer,
-		ServerAddress: encryptedServerAddress,
+		Token:          encryptedToken,
+		CertPath:       encryptedCertPath,
+		Key:            encryptedKey,
+		Container:      encryptedContainer,
+		ServerAddress:  encryptedServerAddress,
+		Backend:        cfg.Backend,
+		CSP:            cfg.CSP,
+		Pkcs11Engine:   cfg.Pkcs11Engine,
+		Pkcs11Module:   cfg.Pkcs11Module,
+		Pkcs11Token:    cfg.Pkcs11Token,
+		Pkcs11KeyLabel: cfg.Pkcs11KeyLabel,
+		Pkcs11Pin:      encryptedPin,
+		TimestampURLs:  cfg.TimestampURLs,
+		Digest:         cfg.Digest,
+		Description:    cfg.Description,
+		DescriptionURL: cfg.DescriptionURL,
+		Workers:        cfg.Workers,
+		TLS:            cfg.TLS,
+		ServerCA:       cfg.ServerCA,
+		ServerName:     cfg.ServerName,
+		ClientCert:     cfg.ClientCert,
+		ClientKey:      cfg.ClientKey,
+		HTTPProxy:      encryptedProxy,
+		Servers:        servers,
+		Profiles:       profiles,
 	}
 
 	configData, err := yaml.Marshal(config)
@@ -178,12 +254,60 @@ func GetDecryptedConfig() (*DecryptedConfig, error) {
 		return nil, fmt.Errorf("error decrypting server address: %v", err)
 	}
 
+	pin, err := decryptValue(config.Pkcs11Pin)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting PKCS#11 PIN: %v", err)
+	}
+
+	proxy, err := decryptValue(config.HTTPProxy)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting HTTP proxy: %v", err)
+	}
+
+	servers := make([]ServerEndpoint, 0, len(config.Servers))
+	for _, s := range config.Servers {
+		decrypted, err := decryptServer(s)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, decrypted)
+	}
+
+	profiles := make([]Profile, 0, len(config.Profiles))
+	for _, p := range config.Profiles {
+		decrypted, err := decryptProfile(p)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", p.Name, err)
+		}
+		profiles = append(profiles, decrypted)
+	}
+
 	return &DecryptedConfig{
-		Token:         token,
-		CertPath:      certPath,
-		Key:           key,
-		Container:     container,
-		ServerAddress: serverAddress,
+		Token:          token,
+		CertPath:       certPath,
+		Key:            key,
+		Container:      container,
+		ServerAddress:  serverAddress,
+		Backend:        config.Backend,
+		CSP:            config.CSP,
+		Pkcs11Engine:   config.Pkcs11Engine,
+		Pkcs11Module:   config.Pkcs11Module,
+		Pkcs11Token:    config.Pkcs11Token,
+		Pkcs11KeyLabel: config.Pkcs11KeyLabel,
+		Pkcs11Pin:      pin,
+		TimestampURLs:  config.TimestampURLs,
+		Digest:         config.Digest,
+		Description:    config.Description,
+		DescriptionURL: config.DescriptionURL,
+		Workers:        config.Workers,
+		TLS:            config.TLS,
+		ServerCA:       config.ServerCA,
+		ServerName:     config.ServerName,
+		ClientCert:     config.ClientCert,
+		ClientKey:      config.ClientKey,
+		HTTPProxy:      proxy,
+		Servers:        servers,
+		Profiles:       profiles,
 	}, nil
 }
 
@@ -193,6 +317,54 @@ type DecryptedConfig struct {
 	Key           string
 	Container     string
 	ServerAddress string
+	// Backend selects the signing backend, signtool when empty
+	Backend string
+	// CSP is the cryptographic provider used by signtool
+	CSP string
+	// Pkcs11Engine is the OpenSSL engine used by osslsigncode to sign with
+	// a hardware token
+	Pkcs11Engine string
+	// Pkcs11Module is the vendor PKCS#11 library of the token
+	Pkcs11Module string
+	// Pkcs11Token is the label or slot ID of the token used by the pkcs11
+	// backend, the first token when empty
+	Pkcs11Token    string
+	Pkcs11KeyLabel string
+	Pkcs11Pin      string
+	// TimestampURLs are the RFC 3161 timestamp authorities tried in order,
+	// the built-in list when empty
+	TimestampURLs []string
+	// Digest is the digest algorithm of signatures, sha256 when empty
+	Digest string
+	// Description and DescriptionURL are embedded in signatures, like the
+	// /d and /du options of signtool
+	Description    string
+	DescriptionURL string
+	// Workers is the number of sign requests processed at the same time,
+	// DefaultWorkers when 0
+	Workers int
+	// TLS connects to the server over TLS, verifying its certificate
+	// against the system roots, or against ServerCA when set. Setting
+	// ServerCA or a client certificate turns it on as well.
+	TLS bool
+	// ServerCA is a PEM bundle pinning the CAs trusted for the server
+	// certificate
+	ServerCA string
+	// ServerName overrides the host name checked in the server certificate,
+	// for servers reached by IP address
+	ServerName string
+	// ClientCert and ClientKey are the PEM certificate and key presented to
+	// servers that require mutual TLS
+	ClientCert string
+	ClientKey  string
+	// HTTPProxy is the proxy URL of the HTTP file transfers, taken from the
+	// environment when empty
+	HTTPProxy string
+	// Servers are the signer servers, tried in order. ServerAddress is
+	// used when empty.
+	Servers []ServerEndpoint
+	// Profiles are the named signing identities besides this one
+	Profiles []Profile
 }
 
 func UpdateToken(token string) error {
@@ -215,6 +387,147 @@ func UpdateServerAddress(serverAddress string) error {
 	return updateConfigField("server_address", serverAddress)
 }
 
+func UpdateBackend(backend string) error {
+	if err := validateBackend(backend); err != nil {
+		return err
+	}
+	return updateConfigField("backend", backend)
+}
+
+func UpdateCSP(csp string) error {
+	return updateConfigField("csp", csp)
+}
+
+func UpdatePkcs11Engine(engine string) error {
+	return updateConfigField("pkcs11_engine", engine)
+}
+
+func UpdatePkcs11Module(module string) error {
+	return updateConfigField("pkcs11_module", module)
+}
+
+func UpdatePkcs11Token(token string) error {
+	return updateConfigField("pkcs11_token", token)
+}
+
+func UpdatePkcs11KeyLabel(label string) error {
+	return updateConfigField("pkcs11_key_label", label)
+}
+
+func UpdatePkcs11Pin(pin string) error {
+	return updateConfigField("pkcs11_pin", pin)
+}
+
+// UpdateTimestampURLs replaces the timestamp authorities. An empty list
+// restores the built-in one.
+func UpdateTimestampURLs(urls []string) error {
+	if err := validateTimestampURLs(urls); err != nil {
+		return err
+	}
+
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+	config.TimestampURLs = urls
+
+	return GenerateConfig(config)
+}
+
+func UpdateDigest(digest string) error {
+	digest = strings.ToLower(digest)
+	if err := validateDigest(digest); err != nil {
+		return err
+	}
+	return updateConfigField("digest", digest)
+}
+
+// UpdateDescription sets the description embedded in signatures and the URL
+// shown with it. Empty values remove them.
+func UpdateDescription(description, descriptionURL string) error {
+	if descriptionURL != "" {
+		if err := validateURL(descriptionURL); err != nil {
+			return fmt.Errorf("invalid description URL: %s", descriptionURL)
+		}
+	}
+
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+	config.Description = description
+	config.DescriptionURL = descriptionURL
+
+	return GenerateConfig(config)
+}
+
+// UpdateTLS turns TLS to the server on or off.
+func UpdateTLS(enabled bool) error {
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+	config.TLS = enabled
+
+	return GenerateConfig(config)
+}
+
+// UpdateServerCA pins the CAs trusted for the server certificate. An empty
+// path restores the system roots.
+func UpdateServerCA(path string) error {
+	if path != "" && !utils.FileExists(path) {
+		return fmt.Errorf("CA file does not exist: %s", path)
+	}
+	return updateConfigField("server_ca", path)
+}
+
+func UpdateServerName(name string) error {
+	return updateConfigField("server_name", name)
+}
+
+// UpdateClientCert sets the certificate and key presented for mutual TLS.
+// Empty paths remove them.
+func UpdateClientCert(certPath, keyPath string) error {
+	if (certPath == "") != (keyPath == "") {
+		return fmt.Errorf("client certificate and key must be set together")
+	}
+	for _, path := range []string{certPath, keyPath} {
+		if path != "" && !utils.FileExists(path) {
+			return fmt.Errorf("file does not exist: %s", path)
+		}
+	}
+
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+	config.ClientCert = certPath
+	config.ClientKey = keyPath
+
+	return GenerateConfig(config)
+}
+
+// UseTLS reports whether the server is reached over TLS.
+func (cfg *DecryptedConfig) UseTLS() bool {
+	return cfg.TLS || cfg.ServerCA != "" || cfg.ClientCert != ""
+}
+
+// UpdateWorkers sets the number of sign requests processed at the same time.
+// 0 restores the default.
+func UpdateWorkers(workers int) error {
+	if workers < 0 {
+		return fmt.Errorf("invalid number of workers: %d", workers)
+	}
+
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+	config.Workers = workers
+
+	return GenerateConfig(config)
+}
+
 func updateConfigField(field, value string) error {
 	config, err := GetDecryptedConfig()
 	if err != nil {
@@ -232,11 +545,33 @@ func updateConfigField(field, value string) error {
 		config.Container = value
 	case "server_address":
 		config.ServerAddress = value
+	case "backend":
+		config.Backend = value
+	case "csp":
+		config.CSP = value
+	case "pkcs11_engine":
+		config.Pkcs11Engine = value
+	case "pkcs11_module":
+		config.Pkcs11Module = value
+	case "pkcs11_token":
+		config.Pkcs11Token = value
+	case "pkcs11_key_label":
+		config.Pkcs11KeyLabel = value
+	case "pkcs11_pin":
+		config.Pkcs11Pin = value
+	case "digest":
+		config.Digest = value
+	case "server_ca":
+		config.ServerCA = value
+	case "server_name":
+		config.ServerName = value
+	case "http_proxy":
+		config.HTTPProxy = value
 	default:
 		return fmt.Errorf("unknown field: %s", field)
 	}
 
-	return GenerateConfig(config.Token, config.CertPath, config.Key, config.Container, config.ServerAddress)
+	return GenerateConfig(config)
 }
 
 func ConfigExists() bool {
@@ -259,33 +594,104 @@ func CreateInitialConfig() error {
 	token := string(tokenBytes)
 	fmt.Println()
 
-	fmt.Print("Enter signing certificate path: ")
+	fmt.Print("Enter signing backend (signtool, osslsigncode, pkcs11, native) [signtool]: ")
+	backend, _ := reader.ReadString('\n')
+	backend = strings.TrimSpace(backend)
+	if err := validateBackend(backend); err != nil {
+		return err
+	}
+
+	cfg := &DecryptedConfig{
+		Token:         token,
+		ServerAddress: serverAddress,
+		Backend:       backend,
+	}
+
+	if err := promptKeyMaterial(reader, backend, cfg); err != nil {
+		return err
+	}
+
+	return GenerateConfig(cfg)
+}
+
+// promptKeyMaterial prompts for the certificate and key settings of the
+// backend.
+func promptKeyMaterial(reader *bufio.Reader, backend string, cfg *DecryptedConfig) error {
+	if backend == "osslsigncode" || backend == "pkcs11" {
+		fmt.Print("Enter PKCS#11 module path")
+		if backend == "osslsigncode" {
+			fmt.Print(" (leave empty to sign with a certificate file)")
+		}
+		fmt.Print(": ")
+		cfg.Pkcs11Module, _ = reader.ReadString('\n')
+		cfg.Pkcs11Module = strings.TrimSpace(cfg.Pkcs11Module)
+	}
+
+	if backend == "pkcs11" {
+		fmt.Print("Enter PKCS#11 token label or slot ID (leave empty to use the first token): ")
+		cfg.Pkcs11Token, _ = reader.ReadString('\n')
+		cfg.Pkcs11Token = strings.TrimSpace(cfg.Pkcs11Token)
+
+		fmt.Print("Enter PKCS#11 key label: ")
+		cfg.Pkcs11KeyLabel, _ = reader.ReadString('\n')
+		cfg.Pkcs11KeyLabel = strings.TrimSpace(cfg.Pkcs11KeyLabel)
+
+		fmt.Print("Enter PKCS#11 PIN: ")
+		pinBytes, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return fmt.Errorf("failed to read PIN: %v", err)
+		}
+		cfg.Pkcs11Pin = string(pinBytes)
+		fmt.Println()
+
+		fmt.Print("Enter signing certificate path (leave empty to read it from the token): ")
+	} else {
+		fmt.Print("Enter signing certificate path: ")
+	}
 	certPath, _ := reader.ReadString('\n')
-	certPath = strings.TrimSpace(certPath)
+	cfg.CertPath = strings.TrimSpace(certPath)
 
 	// Validate certificate path
-	if !filepath.IsAbs(certPath) {
-		return fmt.Errorf("certificate path must be absolute")
+	if cfg.CertPath != "" || backend != "pkcs11" {
+		if !filepath.IsAbs(cfg.CertPath) {
+			return fmt.Errorf("certificate path must be absolute")
+		}
+		if !utils.FileExists(cfg.CertPath) {
+			return fmt.Errorf("certificate file does not exist: %s", cfg.CertPath)
+		}
 	}
-	if !utils.FileExists(certPath) {
-		return fmt.Errorf("certificate file does not exist: %s", certPath)
+
+	if backend == "pkcs11" {
+		return nil
 	}
 
-	fmt.Print("Enter signing key: ")
+	// signtool needs a key name and a container, the other backends a
+	// PKCS#12 password, a key file or a PKCS#11 key URI
+	fileKey := backend == "osslsigncode" || backend == "native"
+	switch {
+	case !fileKey:
+		fmt.Print("Enter signing key: ")
+	case cfg.Pkcs11Module != "":
+		fmt.Print("Enter PKCS#11 key URI: ")
+	default:
+		fmt.Print("Enter PKCS#12 password or private key path: ")
+	}
 	keyBytes, err := term.ReadPassword(int(syscall.Stdin))
 	if err != nil {
 		return fmt.Errorf("failed to read key: %v", err)
 	}
-	key := string(keyBytes)
+	cfg.Key = string(keyBytes)
 	fmt.Println()
 
-	fmt.Print("Enter signing container: ")
-	containerBytes, err := term.ReadPassword(int(syscall.Stdin))
-	if err != nil {
-		return fmt.Errorf("failed to read container: %v", err)
+	if !fileKey {
+		fmt.Print("Enter signing container: ")
+		containerBytes, err := term.ReadPassword(int(syscall.Stdin))
+		if err != nil {
+			return fmt.Errorf("failed to read container: %v", err)
+		}
+		cfg.Container = string(containerBytes)
+		fmt.Println()
 	}
-	container := string(containerBytes)
-	fmt.Println()
 
-	return GenerateConfig(token, certPath, key, container, serverAddress)
+	return nil
 }
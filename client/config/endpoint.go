@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+const (
+	DefaultGRPCPort = "50052"
+	DefaultHTTPPort = "8081"
+)
+
+// ServerEndpoint is a signer server as stored in the configuration. GRPC is
+// host[:port], optionally with a grpc:// or grpcs:// scheme (http:// and
+// https:// are accepted as well). HTTP is the base URL of the HTTP listener,
+// with scheme, host, port and path prefix; it is derived from GRPC when
+// empty. Servers are tried in the order they are listed.
+type ServerEndpoint struct {
+	GRPC string `yaml:"grpc"`
+	HTTP string `yaml:"http,omitempty"`
+}
+
+// Endpoint is a resolved ServerEndpoint.
+type Endpoint struct {
+	// GRPCTarget is the host:port dialed for the gRPC connection
+	GRPCTarget string
+	// HTTPBase is the URL the transfer paths sent by the server are appended
+	// to, without a trailing slash
+	HTTPBase string
+	// TLS reports whether the gRPC connection uses TLS
+	TLS bool
+}
+
+// Endpoints returns the servers to connect to, in order. Without a servers
+// list, the legacy server address is used with the default ports.
+func (cfg *DecryptedConfig) Endpoints() ([]Endpoint, error) {
+	servers := cfg.Servers
+	if len(servers) == 0 {
+		if cfg.ServerAddress == "" {
+			return nil, fmt.Errorf("no server configured")
+		}
+		// The scheme of the legacy address never selected TLS
+		address := strings.TrimPrefix(cfg.ServerAddress, "https://")
+		address = strings.TrimPrefix(address, "http://")
+		servers = []ServerEndpoint{{GRPC: address}}
+	}
+
+	endpoints := make([]Endpoint, 0, len(servers))
+	for _, server := range servers {
+		endpoint, err := resolveEndpoint(server, cfg.UseTLS())
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+func resolveEndpoint(server ServerEndpoint, useTLS bool) (Endpoint, error) {
+	raw := server.GRPC
+	if !strings.Contains(raw, "://") {
+		raw = "//" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Hostname() == "" {
+		return Endpoint{}, fmt.Errorf("invalid gRPC endpoint: %s", server.GRPC)
+	}
+	switch parsed.Scheme {
+	case "":
+	case "grpcs", "https":
+		useTLS = true
+	case "grpc", "http":
+		useTLS = false
+	default:
+		return Endpoint{}, fmt.Errorf("invalid gRPC endpoint %s: unknown scheme %s", server.GRPC, parsed.Scheme)
+	}
+	if strings.Trim(parsed.Path, "/") != "" {
+		return Endpoint{}, fmt.Errorf("invalid gRPC endpoint %s: gRPC endpoints cannot have a path", server.GRPC)
+	}
+
+	port := parsed.Port()
+	if port == "" {
+		port = DefaultGRPCPort
+	}
+	endpoint := Endpoint{
+		GRPCTarget: net.JoinHostPort(parsed.Hostname(), port),
+		TLS:        useTLS,
+	}
+
+	if server.HTTP == "" {
+		scheme := "http"
+		if useTLS {
+			scheme = "https"
+		}
+		endpoint.HTTPBase = fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(parsed.Hostname(), DefaultHTTPPort))
+		return endpoint, nil
+	}
+
+	if err := validateURL(server.HTTP); err != nil {
+		return Endpoint{}, fmt.Errorf("invalid HTTP endpoint %s: %v", server.HTTP, err)
+	}
+	endpoint.HTTPBase = strings.TrimSuffix(server.HTTP, "/")
+	return endpoint, nil
+}
+
+// AddServer appends a server to the list, or replaces the HTTP endpoint of
+// a listed one. The legacy server address is moved to the list first so it
+// keeps its place.
+func AddServer(grpcEndpoint, httpEndpoint string) error {
+	server := ServerEndpoint{GRPC: grpcEndpoint, HTTP: httpEndpoint}
+	if _, err := resolveEndpoint(server, false); err != nil {
+		return err
+	}
+
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+
+	if len(config.Servers) == 0 && config.ServerAddress != "" {
+		address := strings.TrimPrefix(config.ServerAddress, "https://")
+		address = strings.TrimPrefix(address, "http://")
+		config.Servers = []ServerEndpoint{{GRPC: address}}
+	}
+
+	replaced := false
+	for i := range config.Servers {
+		if config.Servers[i].GRPC == grpcEndpoint {
+			config.Servers[i] = server
+			replaced = true
+		}
+	}
+	if !replaced {
+		config.Servers = append(config.Servers, server)
+	}
+
+	return GenerateConfig(config)
+}
+
+func RemoveServer(grpcEndpoint string) error {
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+
+	servers := config.Servers[:0]
+	for _, server := range config.Servers {
+		if server.GRPC != grpcEndpoint {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) == len(config.Servers) {
+		return fmt.Errorf("server %s is not configured", grpcEndpoint)
+	}
+	config.Servers = servers
+
+	return GenerateConfig(config)
+}
+
+// UpdateHTTPProxy sets the proxy used for HTTP file transfers. An empty
+// value restores the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables.
+func UpdateHTTPProxy(proxy string) error {
+	if proxy != "" {
+		if err := validateURL(proxy); err != nil {
+			return fmt.Errorf("invalid proxy URL: %s", proxy)
+		}
+	}
+	return updateConfigField("http_proxy", proxy)
+}
+
+func encryptServer(s ServerEndpoint) (ServerEndpoint, error) {
+	var err error
+	if s.GRPC, err = encryptValue(s.GRPC); err != nil {
+		return s, fmt.Errorf("error encrypting gRPC endpoint: %v", err)
+	}
+	if s.HTTP, err = encryptValue(s.HTTP); err != nil {
+		return s, fmt.Errorf("error encrypting HTTP endpoint: %v", err)
+	}
+	return s, nil
+}
+
+func decryptServer(s ServerEndpoint) (ServerEndpoint, error) {
+	var err error
+	if s.GRPC, err = decryptValue(s.GRPC); err != nil {
+		return s, fmt.Errorf("error decrypting gRPC endpoint: %v", err)
+	}
+	if s.HTTP, err = decryptValue(s.HTTP); err != nil {
+		return s, fmt.Errorf("error decrypting HTTP endpoint: %v", err)
+	}
+	return s, nil
+}
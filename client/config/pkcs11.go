@@ -0,0 +1,6 @@
+//go:build cgo
+
+package config
+
+// pkcs11Supported reports whether the client can load PKCS#11 modules.
+const pkcs11Supported = true
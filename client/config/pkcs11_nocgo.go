@@ -0,0 +1,7 @@
+//go:build !cgo
+
+package config
+
+// pkcs11Supported is false in clients built without cgo, which cannot load
+// PKCS#11 modules.
+const pkcs11Supported = false
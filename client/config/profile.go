@@ -0,0 +1,320 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Digests lists the digest algorithms accepted by the digest settings.
+var Digests = []string{"sha1", "sha256", "sha384", "sha512"}
+
+// Backends lists the signing backends accepted by the backend settings.
+var Backends = []string{"signtool", "osslsigncode", "pkcs11", "native"}
+
+// ErrPKCS11Unavailable is returned when the pkcs11 backend is selected in a
+// client built without cgo.
+var ErrPKCS11Unavailable = errors.New("the pkcs11 backend is not available in this build, which cannot load PKCS#11 modules: use a release build with cgo or build the client with CGO_ENABLED=1")
+
+// Profile is a named signing identity, for example an EV certificate for
+// drivers next to an OV certificate for tools. Jobs name the profile to sign
+// with. The key material (certificate, key, container and PKCS#11 settings)
+// belongs to the profile; the other settings fall back to the main
+// configuration when empty.
+type Profile struct {
+	Name           string   `yaml:"name"`
+	Backend        string   `yaml:"backend,omitempty"`
+	CertPath       string   `yaml:"cert_path,omitempty"`
+	Key            string   `yaml:"key,omitempty"`
+	Container      string   `yaml:"container,omitempty"`
+	Pkcs11Module   string   `yaml:"pkcs11_module,omitempty"`
+	Pkcs11Token    string   `yaml:"pkcs11_token,omitempty"`
+	Pkcs11KeyLabel string   `yaml:"pkcs11_key_label,omitempty"`
+	Pkcs11Pin      string   `yaml:"pkcs11_pin,omitempty"`
+	Digest         string   `yaml:"digest,omitempty"`
+	TimestampURLs  []string `yaml:"timestamp_urls,omitempty"`
+	Description    string   `yaml:"description,omitempty"`
+	DescriptionURL string   `yaml:"description_url,omitempty"`
+}
+
+// ForProfile returns the configuration used to sign jobs of the named
+// profile. The empty name is the main configuration.
+func (cfg *DecryptedConfig) ForProfile(name string) (*DecryptedConfig, error) {
+	if name == "" {
+		return cfg, nil
+	}
+
+	for _, p := range cfg.Profiles {
+		if p.Name != name {
+			continue
+		}
+
+		merged := *cfg
+		merged.Profiles = nil
+		merged.CertPath = p.CertPath
+		merged.Key = p.Key
+		merged.Container = p.Container
+		merged.Pkcs11Module = p.Pkcs11Module
+		merged.Pkcs11Token = p.Pkcs11Token
+		merged.Pkcs11KeyLabel = p.Pkcs11KeyLabel
+		merged.Pkcs11Pin = p.Pkcs11Pin
+		if p.Backend != "" {
+			merged.Backend = p.Backend
+		}
+		if p.Digest != "" {
+			merged.Digest = p.Digest
+		}
+		if len(p.TimestampURLs) > 0 {
+			merged.TimestampURLs = p.TimestampURLs
+		}
+		if p.Description != "" {
+			merged.Description = p.Description
+		}
+		if p.DescriptionURL != "" {
+			merged.DescriptionURL = p.DescriptionURL
+		}
+		return &merged, nil
+	}
+
+	return nil, fmt.Errorf("unknown signing profile: %s", name)
+}
+
+// ValidProfileName reports whether name can be used for a profile. Profiles
+// are announced to the server as a comma separated list, so names are
+// limited to letters, digits, dots, dashes and underscores.
+func ValidProfileName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// AddProfile saves the profile, replacing the profile with the same name.
+func AddProfile(profile Profile) error {
+	if !ValidProfileName(profile.Name) {
+		return fmt.Errorf("invalid profile name: %q", profile.Name)
+	}
+	if err := validateBackend(profile.Backend); err != nil {
+		return err
+	}
+	if err := validateDigest(profile.Digest); err != nil {
+		return err
+	}
+	if err := validateTimestampURLs(profile.TimestampURLs); err != nil {
+		return err
+	}
+	if profile.DescriptionURL != "" {
+		if err := validateURL(profile.DescriptionURL); err != nil {
+			return fmt.Errorf("invalid description URL: %s", profile.DescriptionURL)
+		}
+	}
+
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i, p := range config.Profiles {
+		if p.Name == profile.Name {
+			config.Profiles[i] = profile
+			replaced = true
+		}
+	}
+	if !replaced {
+		config.Profiles = append(config.Profiles, profile)
+	}
+
+	return GenerateConfig(config)
+}
+
+func RemoveProfile(name string) error {
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+
+	profiles := config.Profiles[:0]
+	for _, p := range config.Profiles {
+		if p.Name != name {
+			profiles = append(profiles, p)
+		}
+	}
+	if len(profiles) == len(config.Profiles) {
+		return fmt.Errorf("unknown signing profile: %s", name)
+	}
+	config.Profiles = profiles
+
+	return GenerateConfig(config)
+}
+
+// CreateProfile prompts for the settings of a new profile and saves it.
+func CreateProfile(name string) error {
+	if !ValidProfileName(name) {
+		return fmt.Errorf("invalid profile name: %q", name)
+	}
+
+	config, err := GetDecryptedConfig()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	backend := config.Backend
+	if backend == "" {
+		backend = "signtool"
+	}
+	fmt.Printf("Enter signing backend (signtool, osslsigncode, pkcs11, native) [%s]: ", backend)
+	profileBackend, _ := reader.ReadString('\n')
+	profileBackend = strings.TrimSpace(profileBackend)
+	if profileBackend != "" {
+		backend = profileBackend
+	}
+	if err := validateBackend(backend); err != nil {
+		return err
+	}
+
+	keys := &DecryptedConfig{}
+	if err := promptKeyMaterial(reader, backend, keys); err != nil {
+		return err
+	}
+
+	fmt.Printf("Enter digest algorithm (%s) [%s]: ", strings.Join(Digests, ", "), defaultDigest(config.Digest))
+	digest, _ := reader.ReadString('\n')
+
+	fmt.Print("Enter signature description (leave empty for none): ")
+	description, _ := reader.ReadString('\n')
+
+	fmt.Print("Enter signature description URL (leave empty for none): ")
+	descriptionURL, _ := reader.ReadString('\n')
+
+	fmt.Print("Enter timestamp URLs, comma separated (leave empty for the main configuration's): ")
+	timestampURLs, _ := reader.ReadString('\n')
+
+	return AddProfile(Profile{
+		Name:           name,
+		Backend:        profileBackend,
+		CertPath:       keys.CertPath,
+		Key:            keys.Key,
+		Container:      keys.Container,
+		Pkcs11Module:   keys.Pkcs11Module,
+		Pkcs11Token:    keys.Pkcs11Token,
+		Pkcs11KeyLabel: keys.Pkcs11KeyLabel,
+		Pkcs11Pin:      keys.Pkcs11Pin,
+		Digest:         strings.ToLower(strings.TrimSpace(digest)),
+		TimestampURLs:  SplitList(timestampURLs),
+		Description:    strings.TrimSpace(description),
+		DescriptionURL: strings.TrimSpace(descriptionURL),
+	})
+}
+
+// SplitList splits a comma separated list, dropping empty entries.
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func defaultDigest(digest string) string {
+	if digest == "" {
+		return "sha256"
+	}
+	return digest
+}
+
+func validateDigest(digest string) error {
+	if digest == "" {
+		return nil
+	}
+	for _, d := range Digests {
+		if digest == d {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported digest algorithm: %s", digest)
+}
+
+func validateBackend(backend string) error {
+	if backend == "" {
+		return nil
+	}
+	for _, b := range Backends {
+		if backend != b {
+			continue
+		}
+		if backend == "pkcs11" && !pkcs11Supported {
+			return ErrPKCS11Unavailable
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown signing backend: %s", backend)
+}
+
+func validateTimestampURLs(urls []string) error {
+	for _, u := range urls {
+		if err := validateURL(u); err != nil {
+			return fmt.Errorf("invalid timestamp URL: %s", u)
+		}
+	}
+	return nil
+}
+
+func validateURL(value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("not an http or https URL")
+	}
+	return nil
+}
+
+func encryptProfile(p Profile) (Profile, error) {
+	var err error
+	if p.CertPath, err = encryptValue(p.CertPath); err != nil {
+		return p, fmt.Errorf("error encrypting cert path: %v", err)
+	}
+	if p.Key, err = encryptValue(p.Key); err != nil {
+		return p, fmt.Errorf("error encrypting key: %v", err)
+	}
+	if p.Container, err = encryptValue(p.Container); err != nil {
+		return p, fmt.Errorf("error encrypting container: %v", err)
+	}
+	if p.Pkcs11Pin, err = encryptValue(p.Pkcs11Pin); err != nil {
+		return p, fmt.Errorf("error encrypting PKCS#11 PIN: %v", err)
+	}
+	return p, nil
+}
+
+func decryptProfile(p Profile) (Profile, error) {
+	var err error
+	if p.CertPath, err = decryptValue(p.CertPath); err != nil {
+		return p, fmt.Errorf("error decrypting cert path: %v", err)
+	}
+	if p.Key, err = decryptValue(p.Key); err != nil {
+		return p, fmt.Errorf("error decrypting key: %v", err)
+	}
+	if p.Container, err = decryptValue(p.Container); err != nil {
+		return p, fmt.Errorf("error decrypting container: %v", err)
+	}
+	if p.Pkcs11Pin, err = decryptValue(p.Pkcs11Pin); err != nil {
+		return p, fmt.Errorf("error decrypting PKCS#11 PIN: %v", err)
+	}
+	return p, nil
+}
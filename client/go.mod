@@ -6,12 +6,15 @@ toolchain go1.24.2
 
 require (
 	github.com/AtlasInsideCorp/AtlasInsideAES v1.0.0
+	github.com/YHVCorp/signer-service/authenticode v0.0.0
 	github.com/YHVCorp/signer-service/proto v0.0.0
 	github.com/kardianos/service v1.2.2
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/threatwinds/logger v1.2.2
 	golang.org/x/term v0.32.0
 	google.golang.org/grpc v1.73.0
 	gopkg.in/yaml.v2 v2.4.0
+	software.sslmate.com/src/go-pkcs12 v0.5.0
 )
 
 require (
@@ -47,3 +50,5 @@ require (
 )
 
 replace github.com/YHVCorp/signer-service/proto => ../proto
+
+replace github.com/YHVCorp/signer-service/authenticode => ../authenticode
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/YHVCorp/signer-service/client/config"
 	"github.com/YHVCorp/signer-service/client/serv"
@@ -73,6 +74,166 @@ func main() {
 			}
 			fmt.Println("Server address updated successfully")
 
+		case "addServer":
+			httpEndpoint := ""
+			if len(os.Args) > 3 {
+				httpEndpoint = os.Args[3]
+			}
+			if err := config.AddServer(os.Args[2], httpEndpoint); err != nil {
+				log.Fatalf("Failed to add server: %v", err)
+			}
+			fmt.Println("Server added successfully")
+
+		case "removeServer":
+			if err := config.RemoveServer(os.Args[2]); err != nil {
+				log.Fatalf("Failed to remove server: %v", err)
+			}
+			fmt.Println("Server removed successfully")
+
+		case "listServers":
+			cfg, err := config.GetDecryptedConfig()
+			if err != nil {
+				log.Fatalf("Failed to load configuration: %v", err)
+			}
+			endpoints, err := cfg.Endpoints()
+			if err != nil {
+				log.Fatalf("Invalid server configuration: %v", err)
+			}
+			for i, endpoint := range endpoints {
+				fmt.Printf("%d\tgrpc=%s tls=%t http=%s\n", i+1, endpoint.GRPCTarget, endpoint.TLS, endpoint.HTTPBase)
+			}
+
+		case "setProxy":
+			if err := config.UpdateHTTPProxy(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set proxy: %v", err)
+			}
+			fmt.Println("Proxy updated successfully")
+
+		case "setBackend":
+			if err := config.UpdateBackend(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set signing backend: %v", err)
+			}
+			fmt.Println("Signing backend updated successfully")
+
+		case "setCSP":
+			if err := config.UpdateCSP(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set CSP: %v", err)
+			}
+			fmt.Println("CSP updated successfully")
+
+		case "setPkcs11Engine":
+			if err := config.UpdatePkcs11Engine(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set PKCS#11 engine: %v", err)
+			}
+			fmt.Println("PKCS#11 engine updated successfully")
+
+		case "setPkcs11Module":
+			if err := config.UpdatePkcs11Module(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set PKCS#11 module: %v", err)
+			}
+			fmt.Println("PKCS#11 module updated successfully")
+
+		case "setPkcs11Token":
+			if err := config.UpdatePkcs11Token(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set PKCS#11 token: %v", err)
+			}
+			fmt.Println("PKCS#11 token updated successfully")
+
+		case "setPkcs11KeyLabel":
+			if err := config.UpdatePkcs11KeyLabel(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set PKCS#11 key label: %v", err)
+			}
+			fmt.Println("PKCS#11 key label updated successfully")
+
+		case "setPkcs11Pin":
+			if err := config.UpdatePkcs11Pin(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set PKCS#11 PIN: %v", err)
+			}
+			fmt.Println("PKCS#11 PIN updated successfully")
+
+		case "setTimestampURLs":
+			if err := config.UpdateTimestampURLs(config.SplitList(os.Args[2])); err != nil {
+				log.Fatalf("Failed to set timestamp URLs: %v", err)
+			}
+			fmt.Println("Timestamp URLs updated successfully")
+
+		case "setDigest":
+			if err := config.UpdateDigest(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set digest algorithm: %v", err)
+			}
+			fmt.Println("Digest algorithm updated successfully")
+
+		case "setDescription":
+			descriptionURL := ""
+			if len(os.Args) > 3 {
+				descriptionURL = os.Args[3]
+			}
+			if err := config.UpdateDescription(os.Args[2], descriptionURL); err != nil {
+				log.Fatalf("Failed to set description: %v", err)
+			}
+			fmt.Println("Description updated successfully")
+
+		case "setWorkers":
+			workers, err := strconv.Atoi(os.Args[2])
+			if err != nil {
+				log.Fatalf("Invalid number of workers: %s", os.Args[2])
+			}
+			if err := config.UpdateWorkers(workers); err != nil {
+				log.Fatalf("Failed to set workers: %v", err)
+			}
+			fmt.Println("Workers updated successfully")
+
+		case "setTLS":
+			enabled, err := strconv.ParseBool(os.Args[2])
+			if err != nil {
+				log.Fatalf("Invalid TLS setting: %s", os.Args[2])
+			}
+			if err := config.UpdateTLS(enabled); err != nil {
+				log.Fatalf("Failed to set TLS: %v", err)
+			}
+			fmt.Println("TLS updated successfully")
+
+		case "setServerCA":
+			if err := config.UpdateServerCA(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set server CA: %v", err)
+			}
+			fmt.Println("Server CA updated successfully")
+
+		case "setServerName":
+			if err := config.UpdateServerName(os.Args[2]); err != nil {
+				log.Fatalf("Failed to set server name: %v", err)
+			}
+			fmt.Println("Server name updated successfully")
+
+		case "setClientCert":
+			keyPath := ""
+			if len(os.Args) > 3 {
+				keyPath = os.Args[3]
+			}
+			if err := config.UpdateClientCert(os.Args[2], keyPath); err != nil {
+				log.Fatalf("Failed to set client certificate: %v", err)
+			}
+			fmt.Println("Client certificate updated successfully")
+
+		case "addProfile":
+			if err := config.CreateProfile(os.Args[2]); err != nil {
+				log.Fatalf("Failed to add profile: %v", err)
+			}
+			fmt.Printf("Profile %s saved, restart the service to use it\n", os.Args[2])
+
+		case "removeProfile":
+			if err := config.RemoveProfile(os.Args[2]); err != nil {
+				log.Fatalf("Failed to remove profile: %v", err)
+			}
+			fmt.Printf("Profile %s removed\n", os.Args[2])
+
+		case "listProfiles":
+			cfg, err := config.GetDecryptedConfig()
+			if err != nil {
+				log.Fatalf("Failed to load configuration: %v", err)
+			}
+			listProfiles(cfg)
+
 		case "uninstall":
 			serv.UninstallService()
 			fmt.Println("Service uninstalled successfully")
@@ -87,6 +248,33 @@ func main() {
 	}
 }
 
+func listProfiles(cfg *config.DecryptedConfig) {
+	if len(cfg.Profiles) == 0 {
+		fmt.Println("No signing profiles, every job is signed with the main configuration")
+		return
+	}
+
+	for _, p := range cfg.Profiles {
+		profileCfg, err := cfg.ForProfile(p.Name)
+		if err != nil {
+			continue
+		}
+		backend := profileCfg.Backend
+		if backend == "" {
+			backend = "signtool"
+		}
+		digest := profileCfg.Digest
+		if digest == "" {
+			digest = "sha256"
+		}
+		fmt.Printf("%s\tbackend=%s digest=%s", p.Name, backend, digest)
+		if profileCfg.Description != "" {
+			fmt.Printf(" description=%q", profileCfg.Description)
+		}
+		fmt.Println()
+	}
+}
+
 func Help() {
 	fmt.Println("### SignerServiceClient CLI ###")
 	fmt.Println()
@@ -101,6 +289,28 @@ func Help() {
 	fmt.Println("  setKey <key>             Set the signing key for the service")
 	fmt.Println("  setContainer <container> Set the signing container for the service")
 	fmt.Println("  setServer <address>      Set the server address for the service")
+	fmt.Println("  addServer <grpc> [http]  Add a server endpoint, tried after the ones already listed")
+	fmt.Println("  removeServer <grpc>      Remove a server endpoint")
+	fmt.Println("  listServers              List the server endpoints in the order they are tried")
+	fmt.Println("  setProxy <url>           Set the proxy of HTTP file transfers (\"\" to use the environment)")
+	fmt.Println("  setBackend <name>        Set the signing backend: signtool (default), osslsigncode, pkcs11, native")
+	fmt.Println("  setCSP <provider>        Set the cryptographic provider used by signtool")
+	fmt.Println("  setPkcs11Engine <path>   Set the OpenSSL PKCS#11 engine used by osslsigncode")
+	fmt.Println("  setPkcs11Module <path>   Set the PKCS#11 module of the signing token")
+	fmt.Println("  setPkcs11Token <label>   Set the label or slot ID of the PKCS#11 token")
+	fmt.Println("  setPkcs11KeyLabel <label> Set the label of the signing key on the PKCS#11 token")
+	fmt.Println("  setPkcs11Pin <pin>       Set the PIN of the PKCS#11 token")
+	fmt.Println("  setTimestampURLs <url>[,<url>...] Set the timestamp authorities, tried in order (\"\" for the defaults)")
+	fmt.Println("  setDigest <algorithm>    Set the digest algorithm: sha256 (default), sha384, sha512, sha1")
+	fmt.Println("  setDescription <text> [url] Set the description and URL embedded in signatures")
+	fmt.Printf("  setWorkers <count>       Set the number of sign requests processed at the same time (0 for %d)\n", config.DefaultWorkers)
+	fmt.Println("  setTLS <true|false>      Connect to the server over TLS")
+	fmt.Println("  setServerCA <path>       Set the CA bundle trusted for the server certificate (\"\" for the system roots)")
+	fmt.Println("  setServerName <name>     Set the host name expected in the server certificate")
+	fmt.Println("  setClientCert <cert> <key> Set the client certificate and key for mutual TLS (\"\" \"\" to remove)")
+	fmt.Println("  addProfile <name>        Add or replace a signing profile, prompting for its settings")
+	fmt.Println("  removeProfile <name>     Remove a signing profile")
+	fmt.Println("  listProfiles             List the signing profiles")
 	fmt.Println("  uninstall                Uninstall the SignerServiceClient service")
 	fmt.Println("  help                     Display this help message")
 	fmt.Println()
@@ -3,47 +3,92 @@ package serv
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/YHVCorp/signer-service/client/config"
+	"github.com/YHVCorp/signer-service/client/signer"
 	"github.com/YHVCorp/signer-service/client/utils"
 	pb "github.com/YHVCorp/signer-service/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type SignerClient struct {
-	serverAddress string
-	token         string
-	certPath      string
-	key           string
-	container     string
-	client        pb.SignerServiceClient
-	conn          *grpc.ClientConn
+	clientID  string
+	endpoints []config.Endpoint // tried in order
+	token     string
+	tlsConfig *tls.Config // used with the endpoints that require TLS
+	transport *http.Transport
+	signers   map[string]signer.Signer // by profile, "" is the default one
+	profiles  string                   // announced to the server
+	pool      *workerPool
+	client    pb.SignerServiceClient
+	conn      *grpc.ClientConn
+
+	endpoint   int // index of the endpoint in use
+	endpointMu sync.RWMutex
+
+	session   *jobSession
+	sessionMu sync.Mutex
+	jobs      map[string]context.CancelFunc
+	jobsMu    sync.Mutex
 
 	maxRetries int
 	retryDelay time.Duration
 	isRunning  bool
 }
 
-func NewSignerClient(serverAddress, token, certPath, key, container string) *SignerClient {
+// NewSignerClient returns a client signing with signers, keyed by signing
+// profile. The default signer, for jobs without a profile, has the empty key.
+// At most workers sign requests are processed at the same time. The client
+// connects to the first endpoint that answers; tlsConfig is used for the
+// endpoints that require TLS and proxy selects the proxy of HTTP transfers.
+func NewSignerClient(endpoints []config.Endpoint, token string, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error), signers map[string]signer.Signer, workers int) *SignerClient {
+	clientID, err := os.Hostname()
+	if err != nil || clientID == "" {
+		clientID = "default"
+	}
+
+	var profiles []string
+	for profile := range signers {
+		if profile != "" {
+			profiles = append(profiles, profile)
+		}
+	}
+	sort.Strings(profiles)
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+
 	return &SignerClient{
-		serverAddress: serverAddress,
-		token:         token,
-		certPath:      certPath,
-		key:           key,
-		container:     container,
-		maxRetries:    -1,
-		retryDelay:    1 * time.Second,
-		isRunning:     false,
+		clientID:   clientID,
+		endpoints:  endpoints,
+		token:      token,
+		tlsConfig:  tlsConfig,
+		transport:  transport,
+		signers:    signers,
+		profiles:   strings.Join(profiles, ","),
+		pool:       newWorkerPool(workers, signers),
+		jobs:       make(map[string]context.CancelFunc),
+		maxRetries: -1,
+		retryDelay: 1 * time.Second,
+		isRunning:  false,
 	}
 }
 
@@ -75,6 +120,13 @@ func (c *SignerClient) connectWithRetry() error {
 			break
 		}
 
+		// Fail over to the next server right away, and wait only once every
+		// server has been tried
+		if c.nextEndpoint() {
+			log.Printf("Connection lost, trying the next server")
+			continue
+		}
+
 		retryCount++
 		log.Printf("Connection lost (attempt %d). Retrying in %v...", retryCount, c.retryDelay)
 
@@ -97,12 +149,16 @@ func (c *SignerClient) connect() error {
 		c.conn.Close()
 	}
 
-	server := strings.TrimPrefix(c.serverAddress, "https://")
-	server = strings.TrimPrefix(server, "http://")
+	endpoint := c.currentEndpoint()
+
+	log.Printf("Connecting to gRPC server at %s", endpoint.GRPCTarget)
 
-	log.Printf("Connecting to gRPC server at %s:50052", server)
+	creds := insecure.NewCredentials()
+	if endpoint.TLS {
+		creds = credentials.NewTLS(c.tlsConfig)
+	}
 
-	conn, err := grpc.Dial(fmt.Sprintf("%s:50052", server), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(endpoint.GRPCTarget, grpc.WithTransportCredentials(creds))
 	if err != nil {
 		return utils.Logger.ErrorF("failed to connect to server: %v", err)
 	}
@@ -110,23 +166,54 @@ func (c *SignerClient) connect() error {
 	c.conn = conn
 	c.client = pb.NewSignerServiceClient(conn)
 
-	utils.Logger.Info("Successfully connected to gRPC server at %s:50052", server)
+	utils.Logger.Info("Successfully connected to gRPC server at %s", endpoint.GRPCTarget)
 
 	c.retryDelay = 1 * time.Second
 
 	return nil
 }
 
+func (c *SignerClient) currentEndpoint() config.Endpoint {
+	c.endpointMu.RLock()
+	defer c.endpointMu.RUnlock()
+	return c.endpoints[c.endpoint]
+}
+
+// nextEndpoint moves to the next server in the list and reports whether
+// there was one; after the last server it goes back to the first.
+func (c *SignerClient) nextEndpoint() bool {
+	c.endpointMu.Lock()
+	defer c.endpointMu.Unlock()
+	c.endpoint = (c.endpoint + 1) % len(c.endpoints)
+	return c.endpoint != 0
+}
+
+// httpClient returns a client for the HTTP listener of the server, trusting
+// the same CAs and presenting the same certificate as the gRPC connection,
+// through the configured proxy.
+func (c *SignerClient) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: c.transport}
+}
+
 func (c *SignerClient) listenForSignRequests() error {
-	ctx := context.Background()
-	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+	err := c.listenForJobSession()
+	if err != errSessionUnsupported {
+		return err
+	}
+
+	utils.Logger.Info("Server does not support job sessions, falling back to the sign request stream")
+	return c.listenForSignStream()
+}
+
+func (c *SignerClient) listenForSignStream() error {
+	ctx := c.authContext(context.Background())
 
 	stream, err := c.client.StreamSignRequests(ctx, &pb.Empty{})
 	if err != nil {
 		return utils.Logger.ErrorF("failed to start stream: %v", err)
 	}
 
-	utils.Logger.Info("Listening for sign requests...")
+	utils.Logger.Info("Listening for sign requests as client %s...", c.clientID)
 
 	for c.isRunning {
 		req, err := stream.Recv()
@@ -140,13 +227,49 @@ func (c *SignerClient) listenForSignRequests() error {
 		}
 
 		utils.Logger.Info("Received sign request for file: %s", req.FileName)
-		go c.processSignRequest(req)
+		c.submit(req)
 	}
 
 	return nil
 }
 
-func (c *SignerClient) processSignRequest(req *pb.SignRequest) {
+// submit processes the sign request once a worker is free. The job is
+// registered right away so the server can cancel it while it waits. The HTTP
+// listener of the server that sent the request is recorded with it, as the
+// client may have failed over to another server by the time the job falls
+// back to HTTP transfers, and the URLs are only valid on the issuing one.
+func (c *SignerClient) submit(req *pb.SignRequest) {
+	ctx := c.startJob(req.RequestId)
+	httpBase := c.currentEndpoint().HTTPBase
+
+	go func() {
+		defer c.finishJob(req.RequestId)
+
+		if !c.pool.acquireWorker(ctx) {
+			utils.Logger.Info("Sign request %s cancelled by server while waiting for a worker", req.RequestId)
+			return
+		}
+		defer c.pool.releaseWorker()
+
+		c.processSignRequest(ctx, req, httpBase)
+	}()
+}
+
+func (c *SignerClient) processSignRequest(ctx context.Context, req *pb.SignRequest, httpBase string) {
+	s, ok := c.signers[req.Profile]
+	if !ok {
+		utils.Logger.ErrorF("Sign request %s asks for unknown profile %s", req.RequestId, req.Profile)
+		c.reportError(req.RequestId, fmt.Sprintf("Profile %s is not configured on client %s", req.Profile, c.clientID))
+		return
+	}
+
+	caps := s.Capabilities()
+	if !caps.Supports(req.FileName) {
+		utils.Logger.ErrorF("Backend %s cannot sign %s", caps.Name, req.FileName)
+		c.reportError(req.RequestId, fmt.Sprintf("Backend %s does not support %s files", caps.Name, filepath.Ext(req.FileName)))
+		return
+	}
+
 	// Create temporary directory for processing
 	tempDir, err := os.MkdirTemp("", "signer-client-*")
 	if err != nil {
@@ -155,12 +278,13 @@ func (c *SignerClient) processSignRequest(req *pb.SignRequest) {
 	}
 	defer os.RemoveAll(tempDir)
 
-	downloadURL := fmt.Sprintf("%s:8081%s", c.serverAddress, req.DownloadUrl)
-	uploadURL := fmt.Sprintf("%s:8081%s", c.serverAddress, req.UploadUrl)
-
 	// Download file
 	filePath := filepath.Join(tempDir, req.FileName)
-	if err := c.downloadFile(downloadURL, filePath); err != nil {
+	if err := c.receiveFile(ctx, req, httpBase, filePath); err != nil {
+		if ctx.Err() != nil {
+			utils.Logger.Info("Sign request %s cancelled by server during download", req.RequestId)
+			return
+		}
 		utils.Logger.ErrorF("Failed to download file: %v", err)
 		c.reportError(req.RequestId, fmt.Sprintf("Download failed: %v", err))
 		return
@@ -168,17 +292,59 @@ func (c *SignerClient) processSignRequest(req *pb.SignRequest) {
 
 	utils.Logger.Info("Downloaded file: %s", filePath)
 
-	// Sign file
-	if err := c.signFile(filePath); err != nil {
+	if ctx.Err() != nil {
+		utils.Logger.Info("Sign request %s cancelled by server before signing", req.RequestId)
+		return
+	}
+
+	if err := checkUnsignedFile(filePath); err != nil {
+		utils.Logger.ErrorF("Refusing to sign %s: %v", filePath, err)
+		c.reportError(req.RequestId, fmt.Sprintf("Pre-sign check failed: %v", err))
+		return
+	}
+
+	// Sign file, waiting for the backend when it is busy with other jobs
+	release, ok := c.pool.acquireBackend(ctx, caps.Name)
+	if !ok {
+		utils.Logger.Info("Sign request %s cancelled by server while waiting for backend %s", req.RequestId, caps.Name)
+		return
+	}
+	c.reportProgress(req.RequestId, pb.JobPhase_JOB_PHASE_SIGNING, 0, 0)
+	result, err := s.Sign(ctx, filePath)
+	release()
+	if err != nil {
+		if ctx.Err() != nil {
+			utils.Logger.Info("Sign request %s cancelled by server during signing", req.RequestId)
+			return
+		}
 		utils.Logger.ErrorF("Failed to sign file: %v", err)
 		c.reportError(req.RequestId, fmt.Sprintf("Signing failed: %v", err))
 		return
 	}
 
-	utils.Logger.Info("Successfully signed file: %s", filePath)
+	if err := checkSignedFile(filePath, caps.Timestamping); err != nil {
+		utils.Logger.ErrorF("Signed file %s failed verification: %v", filePath, err)
+		c.reportError(req.RequestId, fmt.Sprintf("Signing failed: %v", err))
+		return
+	}
+
+	if result.TimestampURL != "" {
+		utils.Logger.Info("Successfully signed file: %s, timestamped by %s", filePath, result.TimestampURL)
+	} else {
+		utils.Logger.Info("Successfully signed file: %s", filePath)
+	}
+
+	if ctx.Err() != nil {
+		utils.Logger.Info("Sign request %s cancelled by server before upload", req.RequestId)
+		return
+	}
 
 	// Upload signed file
-	if err := c.uploadFile(uploadURL, filePath); err != nil {
+	if err := c.sendFile(ctx, req, httpBase, filePath, result.TimestampURL); err != nil {
+		if ctx.Err() != nil {
+			utils.Logger.Info("Sign request %s cancelled by server during upload", req.RequestId)
+			return
+		}
 		utils.Logger.ErrorF("Failed to upload file: %v", err)
 		c.reportError(req.RequestId, fmt.Sprintf("Upload failed: %v", err))
 		return
@@ -190,8 +356,53 @@ func (c *SignerClient) processSignRequest(req *pb.SignRequest) {
 	c.reportSuccess(req.RequestId)
 }
 
+// receiveFile downloads the unsigned file over the gRPC connection, falling
+// back to the HTTP URL on httpBase for servers without the transfer RPCs, and
+// checks it against the SHA-256 computed by the server when the file was
+// uploaded.
+func (c *SignerClient) receiveFile(ctx context.Context, req *pb.SignRequest, httpBase, filePath string) error {
+	err := c.downloadFileGRPC(ctx, req.RequestId, filePath,
+		c.progressFunc(req.RequestId, pb.JobPhase_JOB_PHASE_DOWNLOADING))
+	if status.Code(err) == codes.Unimplemented {
+		utils.Logger.Info("Server does not support gRPC transfers, downloading %s over HTTP", req.FileName)
+		c.reportProgress(req.RequestId, pb.JobPhase_JOB_PHASE_DOWNLOADING, 0, 0)
+		err = c.downloadFile(httpBase+req.DownloadUrl, filePath)
+	}
+	if err != nil {
+		return err
+	}
+
+	if req.Sha256 == "" {
+		return nil
+	}
+	_, digest, err := fileDigest(filePath)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(digest, req.Sha256) {
+		return fmt.Errorf("sha256 mismatch: downloaded %s, uploaded %s", digest, req.Sha256)
+	}
+
+	return nil
+}
+
+// sendFile uploads the signed file and the timestamp authority that
+// countersigned it over the gRPC connection, falling back to the HTTP URL on
+// httpBase for servers without the transfer RPCs.
+func (c *SignerClient) sendFile(ctx context.Context, req *pb.SignRequest, httpBase, filePath, timestampURL string) error {
+	err := c.uploadFileGRPC(ctx, req.RequestId, filePath, timestampURL,
+		c.progressFunc(req.RequestId, pb.JobPhase_JOB_PHASE_UPLOADING))
+	if status.Code(err) != codes.Unimplemented {
+		return err
+	}
+
+	utils.Logger.Info("Server does not support gRPC transfers, uploading %s over HTTP", req.FileName)
+	c.reportProgress(req.RequestId, pb.JobPhase_JOB_PHASE_UPLOADING, 0, 0)
+	return c.uploadFile(httpBase+req.UploadUrl, filePath, timestampURL)
+}
+
 func (c *SignerClient) downloadFile(url, filePath string) error {
-	resp, err := http.Get(url)
+	resp, err := c.httpClient(0).Get(url)
 	if err != nil {
 		return err
 	}
@@ -211,7 +422,7 @@ func (c *SignerClient) downloadFile(url, filePath string) error {
 	return err
 }
 
-func (c *SignerClient) uploadFile(url, filePath string) error {
+func (c *SignerClient) uploadFile(url, filePath, timestampURL string) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return err
@@ -231,6 +442,19 @@ func (c *SignerClient) uploadFile(url, filePath string) error {
 		return err
 	}
 
+	_, digest, err := fileDigest(filePath)
+	if err != nil {
+		return err
+	}
+	if err := writer.WriteField("sha256", digest); err != nil {
+		return err
+	}
+	if timestampURL != "" {
+		if err := writer.WriteField("timestamp_url", timestampURL); err != nil {
+			return err
+		}
+	}
+
 	writer.Close()
 
 	req, err := http.NewRequest("POST", url, &requestBody)
@@ -241,10 +465,7 @@ func (c *SignerClient) uploadFile(url, filePath string) error {
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Header.Set("Authorization", "Bearer "+c.token)
 
-	client := &http.Client{
-		Timeout: 5 * time.Minute,
-	}
-	resp, err := client.Do(req)
+	resp, err := c.httpClient(5 * time.Minute).Do(req)
 	if err != nil {
 		return err
 	}
@@ -257,13 +478,16 @@ func (c *SignerClient) uploadFile(url, filePath string) error {
 	return nil
 }
 
-func (c *SignerClient) signFile(filePath string) error {
-	return utils.ExecuteSignTool(c.certPath, c.key, c.container, filePath)
-}
-
 func (c *SignerClient) reportSuccess(requestID string) {
-	ctx := context.Background()
-	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+	if c.sendEvent(&pb.ClientEvent{
+		RequestId: requestID,
+		Phase:     pb.JobPhase_JOB_PHASE_COMPLETED,
+		Message:   "File signed successfully",
+	}) {
+		return
+	}
+
+	ctx := c.authContext(context.Background())
 
 	_, err := c.client.ReportSignResult(ctx, &pb.SignResult{
 		RequestId: requestID,
@@ -281,10 +505,19 @@ func (c *SignerClient) reportError(requestID, errorMsg string) {
 		return
 	}
 
+	if c.sendEvent(&pb.ClientEvent{
+		RequestId: requestID,
+		Phase:     pb.JobPhase_JOB_PHASE_FAILED,
+		Message:   errorMsg,
+	}) {
+		utils.Logger.Info("Successfully reported error for request %s: %s", requestID, errorMsg)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+	ctx = c.authContext(ctx)
 
 	_, err := c.client.ReportSignResult(ctx, &pb.SignResult{
 		RequestId: requestID,
@@ -0,0 +1,88 @@
+package serv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+	"github.com/YHVCorp/signer-service/client/utils"
+)
+
+// checkUnsignedFile rejects malformed PE files before they reach the signer and
+// logs the signature a file already carries, which signing replaces. Files
+// that are not PE images are passed through.
+func checkUnsignedFile(filePath string) error {
+	file, pe, err := openPE(filePath)
+	if errors.Is(err, authenticode.ErrNotPE) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid PE file: %v", err)
+	}
+	defer file.Close()
+
+	if !pe.Signed() {
+		return nil
+	}
+
+	sig, err := pe.Signature()
+	if err != nil {
+		utils.Logger.Info("Existing signature of %s is invalid and will be replaced: %v", filePath, err)
+		return nil
+	}
+	utils.Logger.Info("%s is already signed by %s, the signature will be replaced", filePath, sig.Thumbprint())
+
+	return nil
+}
+
+// checkSignedFile makes sure the signer left a valid Authenticode signature
+// before the file is sent back to the server. The timestamp is only required
+// from backends that timestamp their signatures.
+func checkSignedFile(filePath string, requireTimestamp bool) error {
+	file, pe, err := openPE(filePath)
+	if errors.Is(err, authenticode.ErrNotPE) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid PE file after signing: %v", err)
+	}
+	defer file.Close()
+
+	sig, err := pe.Signature()
+	if err != nil {
+		return fmt.Errorf("invalid signature after signing: %v", err)
+	}
+	if sig.Timestamp == nil {
+		if requireTimestamp {
+			return fmt.Errorf("signature has no timestamp")
+		}
+		utils.Logger.Info("Signed %s with certificate %s, without timestamp", filePath, sig.Thumbprint())
+		return nil
+	}
+
+	utils.Logger.Info("Signed %s with certificate %s, timestamped at %s", filePath, sig.Thumbprint(), sig.Timestamp.Time)
+
+	return nil
+}
+
+func openPE(filePath string) (*os.File, *authenticode.File, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	pe, err := authenticode.Parse(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, pe, nil
+}
@@ -0,0 +1,85 @@
+package serv
+
+import (
+	"context"
+
+	"github.com/YHVCorp/signer-service/client/signer"
+)
+
+// workerPool bounds the sign requests processed at the same time. A job holds
+// a worker from the moment it is received until it is reported, and a backend
+// slot while it is being signed, so backends working on a hardware token sign
+// one file at a time while other jobs download and upload.
+type workerPool struct {
+	workers  chan struct{}
+	backends map[string]chan struct{} // by backend name, only limited backends
+	capacity int
+}
+
+// newWorkerPool returns a pool of workers for the signers. Backends of the
+// same name share their limit, because they usually share the token.
+func newWorkerPool(workers int, signers map[string]signer.Signer) *workerPool {
+	limits := make(map[string]int)
+	unlimited := false
+	for _, s := range signers {
+		caps := s.Capabilities()
+		if caps.MaxConcurrent <= 0 {
+			unlimited = true
+			continue
+		}
+		if limit, exists := limits[caps.Name]; !exists || caps.MaxConcurrent < limit {
+			limits[caps.Name] = caps.MaxConcurrent
+		}
+	}
+
+	pool := &workerPool{
+		workers:  make(chan struct{}, workers),
+		backends: make(map[string]chan struct{}, len(limits)),
+		capacity: workers,
+	}
+
+	total := 0
+	for name, limit := range limits {
+		pool.backends[name] = make(chan struct{}, limit)
+		total += limit
+	}
+	// More jobs than the backends can sign would only wait on this client
+	// while another one could take them
+	if !unlimited && total < pool.capacity {
+		pool.capacity = total
+	}
+
+	return pool
+}
+
+// acquireWorker waits for a free worker. It returns false when ctx is done
+// first.
+func (p *workerPool) acquireWorker(ctx context.Context) bool {
+	return acquire(ctx, p.workers)
+}
+
+func (p *workerPool) releaseWorker() {
+	<-p.workers
+}
+
+// acquireBackend waits until the backend can sign another file and returns
+// the function releasing it. It returns false when ctx is done first.
+func (p *workerPool) acquireBackend(ctx context.Context, name string) (func(), bool) {
+	slots, limited := p.backends[name]
+	if !limited {
+		return func() {}, true
+	}
+	if !acquire(ctx, slots) {
+		return nil, false
+	}
+	return func() { <-slots }, true
+}
+
+func acquire(ctx context.Context, slots chan struct{}) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -1,11 +1,13 @@
 package serv
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/YHVCorp/signer-service/client/config"
+	"github.com/YHVCorp/signer-service/client/signer"
 	"github.com/YHVCorp/signer-service/client/utils"
 	"github.com/kardianos/service"
 )
@@ -31,7 +33,51 @@ func (p *program) run() {
 		utils.Logger.Fatal("Failed to load configuration: %v", err)
 	}
 
-	client := NewSignerClient(cfg.ServerAddress, cfg.Token, cfg.CertPath, cfg.Key, cfg.Container)
+	s, err := signer.New(cfg)
+	if err != nil {
+		utils.Logger.Fatal("Failed to create signer: %v", err)
+	}
+
+	if err := s.HealthCheck(context.Background()); err != nil {
+		utils.Logger.ErrorF("Signing backend %s is not healthy: %v", s.Capabilities().Name, err)
+	} else {
+		utils.Logger.Info("Using signing backend %s", s.Capabilities().Name)
+	}
+
+	signers := map[string]signer.Signer{"": s}
+	for _, profile := range cfg.Profiles {
+		s, err := newProfileSigner(cfg, profile.Name)
+		if err != nil {
+			// The profile is not announced, so the server does not route its
+			// jobs here
+			utils.Logger.ErrorF("Signing profile %s disabled: %v", profile.Name, err)
+			continue
+		}
+		signers[profile.Name] = s
+	}
+
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = config.DefaultWorkers
+	}
+
+	endpoints, err := cfg.Endpoints()
+	if err != nil {
+		utils.Logger.Fatal("Invalid server configuration: %v", err)
+	}
+
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		utils.Logger.Fatal("Invalid TLS configuration: %v", err)
+	}
+
+	proxy, err := newProxyFunc(cfg)
+	if err != nil {
+		utils.Logger.Fatal("Invalid proxy configuration: %v", err)
+	}
+
+	client := NewSignerClient(endpoints, cfg.Token, tlsConfig, proxy, signers, workers)
+	utils.Logger.Info("Processing up to %d sign requests at the same time", client.pool.capacity)
 	err = client.Start()
 	if err != nil {
 		utils.Logger.Fatal("Failed to start signer client: %v", err)
@@ -41,3 +87,23 @@ func (p *program) run() {
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
 	<-signals
 }
+
+func newProfileSigner(cfg *config.DecryptedConfig, name string) (signer.Signer, error) {
+	profileCfg, err := cfg.ForProfile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := signer.New(profileCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.HealthCheck(context.Background()); err != nil {
+		utils.Logger.ErrorF("Signing backend %s of profile %s is not healthy: %v", s.Capabilities().Name, name, err)
+	} else {
+		utils.Logger.Info("Using signing backend %s for profile %s", s.Capabilities().Name, name)
+	}
+
+	return s, nil
+}
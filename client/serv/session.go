@@ -0,0 +1,160 @@
+package serv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/YHVCorp/signer-service/client/utils"
+	pb "github.com/YHVCorp/signer-service/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// progressInterval limits how often byte counts are reported for a phase.
+const progressInterval = time.Second
+
+var errSessionUnsupported = errors.New("server does not support job sessions")
+
+// jobSession is an open JobSession stream. Sends are serialized because every
+// running job reports its progress on the same stream.
+type jobSession struct {
+	stream pb.SignerService_JobSessionClient
+	mu     sync.Mutex
+}
+
+func (js *jobSession) send(event *pb.ClientEvent) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.stream.Send(event)
+}
+
+// listenForJobSession receives sign requests and cancellations over the
+// JobSession stream. It returns errSessionUnsupported when the server only
+// offers StreamSignRequests.
+func (c *SignerClient) listenForJobSession() error {
+	ctx, cancel := context.WithCancel(c.authContext(context.Background()))
+	defer cancel()
+
+	stream, err := c.client.JobSession(ctx)
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return errSessionUnsupported
+		}
+		return utils.Logger.ErrorF("failed to open job session: %v", err)
+	}
+
+	session := &jobSession{stream: stream}
+	c.setSession(session)
+	defer c.setSession(nil)
+
+	utils.Logger.Info("Job session opened, waiting for sign requests...")
+
+	for {
+		cmd, err := stream.Recv()
+		if err != nil {
+			if status.Code(err) == codes.Unimplemented {
+				return errSessionUnsupported
+			}
+			return utils.Logger.ErrorF("error receiving command: %v", err)
+		}
+
+		switch cmd.Type {
+		case pb.CommandType_COMMAND_TYPE_SIGN:
+			req := cmd.SignRequest
+			if req == nil {
+				continue
+			}
+			utils.Logger.Info("Received sign request for file: %s", req.FileName)
+			if err := session.send(&pb.ClientEvent{
+				RequestId: req.RequestId,
+				Phase:     pb.JobPhase_JOB_PHASE_ACKNOWLEDGED,
+			}); err != nil {
+				utils.Logger.ErrorF("Failed to acknowledge request %s: %v", req.RequestId, err)
+			}
+			c.submit(req)
+		case pb.CommandType_COMMAND_TYPE_CANCEL:
+			utils.Logger.Info("Server cancelled sign request %s", cmd.RequestId)
+			c.cancelJob(cmd.RequestId)
+		}
+	}
+}
+
+func (c *SignerClient) setSession(session *jobSession) {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+	c.session = session
+}
+
+// sendEvent reports an event on the job session. It returns false when no
+// session is open or the event could not be sent, so the caller can fall back
+// to the unary RPCs.
+func (c *SignerClient) sendEvent(event *pb.ClientEvent) bool {
+	c.sessionMu.Lock()
+	session := c.session
+	c.sessionMu.Unlock()
+
+	if session == nil {
+		return false
+	}
+	if err := session.send(event); err != nil {
+		utils.Logger.ErrorF("Failed to send %s event for request %s: %v", event.Phase, event.RequestId, err)
+		return false
+	}
+	return true
+}
+
+func (c *SignerClient) reportProgress(requestID string, phase pb.JobPhase, done, total int64) {
+	c.sendEvent(&pb.ClientEvent{
+		RequestId:  requestID,
+		Phase:      phase,
+		BytesDone:  done,
+		BytesTotal: total,
+	})
+}
+
+// progressFunc returns a callback for the transfer functions that reports
+// the phase right away and then at most once per progressInterval, plus the
+// final byte count.
+func (c *SignerClient) progressFunc(requestID string, phase pb.JobPhase) func(done, total int64) {
+	var last time.Time
+	return func(done, total int64) {
+		if done < total && time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+		c.reportProgress(requestID, phase, done, total)
+	}
+}
+
+// startJob registers a running job so the server can cancel it, and returns
+// the context the job has to run under.
+func (c *SignerClient) startJob(requestID string) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+	c.jobs[requestID] = cancel
+
+	return ctx
+}
+
+func (c *SignerClient) finishJob(requestID string) {
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+
+	if cancel, exists := c.jobs[requestID]; exists {
+		cancel()
+		delete(c.jobs, requestID)
+	}
+}
+
+func (c *SignerClient) cancelJob(requestID string) {
+	c.jobsMu.Lock()
+	defer c.jobsMu.Unlock()
+
+	if cancel, exists := c.jobs[requestID]; exists {
+		cancel()
+	}
+}
@@ -0,0 +1,57 @@
+package serv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/YHVCorp/signer-service/client/config"
+)
+
+// newTLSConfig returns the TLS settings used for the gRPC connections and
+// the HTTP transfers to the servers that require TLS.
+func newTLSConfig(cfg *config.DecryptedConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.ServerCA != "" {
+		data, err := os.ReadFile(cfg.ServerCA)
+		if err != nil {
+			return nil, fmt.Errorf("error reading server CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", cfg.ServerCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// newProxyFunc returns the proxy selection of HTTP transfers: the configured
+// proxy, or the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+func newProxyFunc(cfg *config.DecryptedConfig) (func(*http.Request) (*url.URL, error), error) {
+	if cfg.HTTPProxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(cfg.HTTPProxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid HTTP proxy: %v", err)
+	}
+	return http.ProxyURL(proxyURL), nil
+}
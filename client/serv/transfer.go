@@ -0,0 +1,167 @@
+package serv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+
+	pb "github.com/YHVCorp/signer-service/proto"
+	"google.golang.org/grpc/metadata"
+)
+
+const transferChunkSize = 256 * 1024
+
+func (c *SignerClient) authContext(ctx context.Context) context.Context {
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token, "client-id", c.clientID)
+	if c.profiles != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "signing-profiles", c.profiles)
+	}
+	return metadata.AppendToOutgoingContext(ctx, "client-capacity", strconv.Itoa(c.pool.capacity))
+}
+
+// downloadFileGRPC receives the unsigned file over the gRPC connection,
+// verifying the CRC-32 of every chunk and the SHA-256 of the whole file.
+func (c *SignerClient) downloadFileGRPC(ctx context.Context, requestID, filePath string, progress func(done, total int64)) error {
+	ctx, cancel := context.WithCancel(c.authContext(ctx))
+	defer cancel()
+
+	stream, err := c.client.DownloadFile(ctx, &pb.FileRequest{RequestId: requestID})
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	hasher := sha256.New()
+	writer := io.MultiWriter(out, hasher)
+
+	var received, totalSize int64
+	var expectedDigest string
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if chunk.Offset == 0 {
+			totalSize = chunk.TotalSize
+			expectedDigest = chunk.Sha256
+		}
+		if chunk.Offset != received {
+			return fmt.Errorf("chunk at offset %d, expected %d", chunk.Offset, received)
+		}
+		if crc32.ChecksumIEEE(chunk.Data) != chunk.Crc32 {
+			return fmt.Errorf("checksum mismatch in chunk at offset %d", chunk.Offset)
+		}
+		if _, err := writer.Write(chunk.Data); err != nil {
+			return err
+		}
+		received += int64(len(chunk.Data))
+		progress(received, totalSize)
+	}
+
+	if received != totalSize {
+		return fmt.Errorf("received %d bytes, expected %d", received, totalSize)
+	}
+	if digest := hex.EncodeToString(hasher.Sum(nil)); digest != expectedDigest {
+		return fmt.Errorf("sha256 mismatch: received %s, expected %s", digest, expectedDigest)
+	}
+
+	return nil
+}
+
+// uploadFileGRPC sends the signed file over the gRPC connection. The first
+// chunk announces the size and SHA-256 of the file so the server can verify
+// what it received, and the timestamp authority used.
+func (c *SignerClient) uploadFileGRPC(ctx context.Context, requestID, filePath, timestampURL string, progress func(done, total int64)) error {
+	size, digest, err := fileDigest(filePath)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(c.authContext(ctx))
+	defer cancel()
+
+	stream, err := c.client.UploadSignedFile(ctx)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, transferChunkSize)
+	var offset int64
+	for {
+		n, err := file.Read(buf)
+		if n > 0 || offset == 0 {
+			chunk := &pb.FileChunk{
+				RequestId: requestID,
+				Offset:    offset,
+				Data:      buf[:n],
+				Crc32:     crc32.ChecksumIEEE(buf[:n]),
+			}
+			if offset == 0 {
+				chunk.TotalSize = size
+				chunk.Sha256 = digest
+				chunk.TimestampUrl = timestampURL
+			}
+			if sendErr := stream.Send(chunk); sendErr != nil {
+				// The server's reason is returned by CloseAndRecv
+				if _, recvErr := stream.CloseAndRecv(); recvErr != nil {
+					return recvErr
+				}
+				return sendErr
+			}
+			offset += int64(n)
+			progress(offset, size)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if result.Sha256 != digest {
+		return fmt.Errorf("server stored sha256 %s, expected %s", result.Sha256, digest)
+	}
+
+	return nil
+}
+
+func fileDigest(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hasher.Sum(nil)), nil
+}
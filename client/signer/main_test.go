@@ -0,0 +1,13 @@
+package signer
+
+import (
+	"os"
+	"testing"
+
+	"github.com/YHVCorp/signer-service/client/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLogger("stdout")
+	os.Exit(m.Run())
+}
@@ -0,0 +1,212 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+	"github.com/YHVCorp/signer-service/client/config"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Native signs PE files in Go, without any external tool, so it runs in CI
+// containers on any OS. The key comes from a PKCS#12 file, a PEM key file or
+// any crypto.Signer.
+type Native struct {
+	key   crypto.Signer
+	chain []*x509.Certificate
+	opts  NativeOptions
+	tsa   *timestamper
+}
+
+// NativeOptions tunes the native backend. With a fixed signing time and no
+// timestamp, RSA signatures are reproducible byte for byte.
+type NativeOptions struct {
+	// SigningTime is recorded in signatures instead of the current time.
+	SigningTime time.Time
+	// NoTimestamp skips the timestamp request. Signatures without a
+	// timestamp stop validating when the certificate expires.
+	NoTimestamp bool
+	// TimestampURLs are the timestamp authorities to try in order,
+	// DefaultTimestampURLs when empty.
+	TimestampURLs []string
+	// Hash is the digest algorithm of the signature and the timestamp,
+	// SHA-256 when zero.
+	Hash crypto.Hash
+	// Description and URL are embedded in the signature.
+	Description string
+	URL         string
+}
+
+// NewNative returns a native backend signing with key, whose certificate is
+// the first of chain.
+func NewNative(key crypto.Signer, chain []*x509.Certificate, opts NativeOptions) *Native {
+	return &Native{
+		key:   key,
+		chain: chain,
+		opts:  opts,
+		tsa:   newTimestamper(opts.TimestampURLs),
+	}
+}
+
+// newNativeFromConfig loads the key pair of the client config: a PKCS#12 cert
+// path with the key as its password, or a certificate file with the key as
+// the path of a PEM private key.
+func newNativeFromConfig(cfg *config.DecryptedConfig) (Signer, error) {
+	var (
+		key   crypto.Signer
+		chain []*x509.Certificate
+		err   error
+	)
+	if isPkcs12(cfg.CertPath) {
+		key, chain, err = loadPkcs12(cfg.CertPath, cfg.Key)
+	} else {
+		key, chain, err = loadKeyPair(cfg.CertPath, cfg.Key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := newSignSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewNative(key, chain, NativeOptions{
+		TimestampURLs: cfg.TimestampURLs,
+		Hash:          settings.hash,
+		Description:   settings.description,
+		URL:           settings.descriptionURL,
+	})
+	if err := s.checkKeyPair(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Native) Sign(ctx context.Context, filePath string) (*Result, error) {
+	h := s.opts.Hash
+	if h == 0 {
+		h = crypto.SHA256
+	}
+
+	result := &Result{}
+	opts := &authenticode.SignOptions{
+		Hash:        h,
+		SigningTime: s.opts.SigningTime,
+		Description: s.opts.Description,
+		URL:         s.opts.URL,
+	}
+	if !s.opts.NoTimestamp {
+		opts.Timestamp = s.tsa.authenticode(ctx, h, &result.TimestampURL)
+	}
+
+	if err := signPE(filePath, s.key, s.chain, opts); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *Native) Capabilities() Capabilities {
+	return Capabilities{
+		Name:           "native",
+		FileExtensions: peExtensions,
+		Timestamping:   !s.opts.NoTimestamp,
+	}
+}
+
+func (s *Native) HealthCheck(_ context.Context) error {
+	if err := s.checkKeyPair(); err != nil {
+		return err
+	}
+
+	cert := s.chain[0]
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("certificate %s is valid from %s to %s", authenticode.Thumbprint(cert), cert.NotBefore, cert.NotAfter)
+	}
+
+	return nil
+}
+
+// checkKeyPair makes sure the key belongs to the signer certificate.
+func (s *Native) checkKeyPair() error {
+	if len(s.chain) == 0 {
+		return errors.New("missing signer certificate")
+	}
+
+	pub, ok := s.key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(s.chain[0].PublicKey) {
+		return errors.New("private key does not match the signer certificate")
+	}
+
+	return nil
+}
+
+func loadPkcs12(path, password string) (crypto.Signer, []*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	privateKey, cert, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+
+	key, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+
+	return key, append([]*x509.Certificate{cert}, caCerts...), nil
+}
+
+func loadKeyPair(certPath, keyPath string) (crypto.Signer, []*x509.Certificate, error) {
+	chain, err := loadCertificates(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		var privateKey interface{}
+		switch block.Type {
+		case "PRIVATE KEY":
+			privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid private key in %s: %v", keyPath, err)
+		}
+
+		key, ok := privateKey.(crypto.Signer)
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported private key type %T", privateKey)
+		}
+		return key, chain, nil
+	}
+
+	return nil, nil, fmt.Errorf("no private key found in %s", keyPath)
+}
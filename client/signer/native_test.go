@@ -0,0 +1,93 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// The test key pair and the unsigned fixture are checked in testdata, so a
+// signature with a fixed signing time and no timestamp never changes.
+const testdataThumbprint = "A238ECD9C2158F8A5DAACD6EE341DA7863FC8F32"
+
+func TestNativeSignGolden(t *testing.T) {
+	key, chain, err := loadKeyPair(filepath.Join("testdata", "cert.pem"), filepath.Join("testdata", "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := os.ReadFile(filepath.Join("testdata", "unsigned.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "app.exe")
+	if err := os.WriteFile(path, unsigned, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewNative(key, chain, NativeOptions{
+		SigningTime: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+		NoTimestamp: true,
+		Description: "Signer Service Test",
+		URL:         "https://example.com",
+	})
+	result, err := s.Sign(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+	if result.TimestampURL != "" {
+		t.Errorf("TimestampURL = %s without timestamping", result.TimestampURL)
+	}
+
+	signed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goldenPath := filepath.Join("testdata", "signed.exe")
+	if *update {
+		if err := os.WriteFile(goldenPath, signed, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signed, golden) {
+		offset := 0
+		for offset < len(signed) && offset < len(golden) && signed[offset] == golden[offset] {
+			offset++
+		}
+		t.Fatalf("signed file (%d bytes) differs from %s (%d bytes) at offset %d", len(signed), goldenPath, len(golden), offset)
+	}
+
+	original, err := authenticode.Parse(bytes.NewReader(unsigned), int64(len(unsigned)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe, err := authenticode.Parse(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := pe.Signature()
+	if err != nil {
+		t.Fatalf("Signature() = %v", err)
+	}
+	if sig.Thumbprint() != testdataThumbprint {
+		t.Errorf("Thumbprint() = %s, want %s", sig.Thumbprint(), testdataThumbprint)
+	}
+	if sig.Timestamp != nil {
+		t.Error("signature is timestamped")
+	}
+	if err := authenticode.CompareContent(original, pe); err != nil {
+		t.Errorf("CompareContent() = %v", err)
+	}
+}
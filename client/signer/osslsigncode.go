@@ -0,0 +1,188 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/YHVCorp/signer-service/client/config"
+	"github.com/YHVCorp/signer-service/client/utils"
+)
+
+// OsslSignCode signs files with osslsigncode, which runs on Linux and macOS.
+// The key is taken from one of:
+//   - a PKCS#12 file (cert path ending in .pfx or .p12), key is its password
+//   - a PKCS#11 token through the OpenSSL engine, when a PKCS#11 module is
+//     configured; key is the PKCS#11 URI of the private key, which may carry
+//     the PIN as pin-value
+//   - a certificate file and a private key file, key is the key file path
+type OsslSignCode struct {
+	certPath     string
+	key          string
+	pkcs11Engine string
+	pkcs11Module string
+	tsa          *timestamper
+	settings     signSettings
+}
+
+func NewOsslSignCode(cfg *config.DecryptedConfig) (*OsslSignCode, error) {
+	settings, err := newSignSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OsslSignCode{
+		certPath:     cfg.CertPath,
+		key:          cfg.Key,
+		pkcs11Engine: cfg.Pkcs11Engine,
+		pkcs11Module: cfg.Pkcs11Module,
+		tsa:          newTimestamper(cfg.TimestampURLs),
+		settings:     settings,
+	}, nil
+}
+
+// Sign signs the file and then adds the timestamp in a second osslsigncode
+// call, so a timestamp authority that is down is replaced by the next one
+// without signing again.
+func (s *OsslSignCode) Sign(ctx context.Context, filePath string) (*Result, error) {
+	// osslsigncode cannot sign in place
+	signedPath := filePath + ".signed"
+	defer os.Remove(signedPath)
+	timestampedPath := filePath + ".timestamped"
+	defer os.Remove(timestampedPath)
+
+	args, cleanup, err := s.signArgs(filePath, signedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	output, err := utils.ExecuteContext(ctx, "osslsigncode", utils.GetMyPath(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("osslsigncode failed: %v: %s", err, strings.TrimSpace(output))
+	}
+
+	url, err := s.tsa.try(ctx, func(url string) error {
+		output, err := utils.ExecuteContext(ctx, "osslsigncode", utils.GetMyPath(),
+			s.timestampArgs(url, signedPath, timestampedPath)...)
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(timestampedPath, filePath); err != nil {
+		return nil, fmt.Errorf("failed to replace unsigned file: %v", err)
+	}
+
+	return &Result{TimestampURL: url}, nil
+}
+
+// signArgs returns the osslsigncode arguments signing inPath into outPath.
+// osslsigncode recognizes PE, MSI and CAB files by their content, so the
+// arguments are the same for every format. The returned function removes the
+// temporary password file, if any.
+func (s *OsslSignCode) signArgs(inPath, outPath string) ([]string, func(), error) {
+	// Example command:
+	// osslsigncode sign -h sha256 -pkcs12 "<CERT>" -readpass "<PASSWORD_FILE>" -in "<FILE_TO_SIGN>" -out "<SIGNED_FILE>"
+	args := []string{
+		"sign",
+		"-h", s.settings.digestName(),
+	}
+	if s.settings.description != "" {
+		args = append(args, "-n", s.settings.description)
+	}
+	if s.settings.descriptionURL != "" {
+		args = append(args, "-i", s.settings.descriptionURL)
+	}
+
+	keyArgs, cleanup, err := s.keyArgs()
+	if err != nil {
+		return nil, nil, err
+	}
+	args = append(args, keyArgs...)
+	return append(args, "-in", inPath, "-out", outPath), cleanup, nil
+}
+
+// timestampArgs returns the osslsigncode arguments adding a timestamp from
+// the authority at url to the signature of inPath.
+func (s *OsslSignCode) timestampArgs(url, inPath, outPath string) []string {
+	// osslsigncode add -h sha256 -ts http://timestamp.digicert.com -in "<SIGNED_FILE>" -out "<TIMESTAMPED_FILE>"
+	return []string{"add", "-h", s.settings.digestName(), "-ts", url, "-in", inPath, "-out", outPath}
+}
+
+// keyArgs returns the osslsigncode arguments selecting the signing key. The
+// PKCS#12 password is passed in a temporary file rather than on the command
+// line, where other users could read it from the process list.
+func (s *OsslSignCode) keyArgs() ([]string, func(), error) {
+	noop := func() {}
+
+	if s.pkcs11Module != "" {
+		args := []string{"-pkcs11module", s.pkcs11Module, "-certs", s.certPath, "-key", s.key}
+		if s.pkcs11Engine != "" {
+			args = append([]string{"-pkcs11engine", s.pkcs11Engine}, args...)
+		}
+		return args, noop, nil
+	}
+
+	if !isPkcs12(s.certPath) {
+		return []string{"-certs", s.certPath, "-key", s.key}, noop, nil
+	}
+
+	passFile, err := os.CreateTemp("", "signer-pass-*")
+	if err != nil {
+		return nil, noop, fmt.Errorf("failed to create password file: %v", err)
+	}
+	cleanup := func() { os.Remove(passFile.Name()) }
+
+	_, err = passFile.WriteString(s.key)
+	if closeErr := passFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return nil, noop, fmt.Errorf("failed to write password file: %v", err)
+	}
+
+	return []string{"-pkcs12", s.certPath, "-readpass", passFile.Name()}, cleanup, nil
+}
+
+func (s *OsslSignCode) Capabilities() Capabilities {
+	caps := Capabilities{
+		Name:           "osslsigncode",
+		FileExtensions: []string{".exe", ".dll", ".sys", ".ocx", ".efi", ".msi", ".cab"},
+		Timestamping:   true,
+	}
+	if s.pkcs11Module != "" {
+		// Hardware tokens handle one signing session at a time
+		caps.MaxConcurrent = 1
+	}
+	return caps
+}
+
+func (s *OsslSignCode) HealthCheck(_ context.Context) error {
+	if _, err := exec.LookPath("osslsigncode"); err != nil {
+		return fmt.Errorf("osslsigncode not found in PATH: %v", err)
+	}
+
+	if _, err := os.Stat(s.certPath); err != nil {
+		return fmt.Errorf("certificate not found: %v", err)
+	}
+
+	if s.pkcs11Module != "" {
+		if _, err := os.Stat(s.pkcs11Module); err != nil {
+			return fmt.Errorf("PKCS#11 module not found: %v", err)
+		}
+	} else if !isPkcs12(s.certPath) {
+		if _, err := os.Stat(s.key); err != nil {
+			return fmt.Errorf("private key not found: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+	"github.com/YHVCorp/signer-service/client/config"
+)
+
+func TestOsslSignCodeArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.DecryptedConfig
+		file string
+		// The path of the password file is replaced by <pass>
+		want     []string
+		password string
+	}{
+		{
+			name: "pkcs12",
+			cfg:  config.DecryptedConfig{CertPath: "/etc/signer/cert.pfx", Key: "secret"},
+			file: "app.exe",
+			want: []string{"sign", "-h", "sha256", "-pkcs12", "/etc/signer/cert.pfx", "-readpass", "<pass>",
+				"-in", "app.exe", "-out", "app.exe.signed"},
+			password: "secret",
+		},
+		{
+			name: "certificate and key files",
+			cfg: config.DecryptedConfig{CertPath: "/etc/signer/cert.pem", Key: "/etc/signer/key.pem",
+				Digest: "sha384", Description: "Agent", DescriptionURL: "https://example.com"},
+			file: "app.dll",
+			want: []string{"sign", "-h", "sha384", "-n", "Agent", "-i", "https://example.com",
+				"-certs", "/etc/signer/cert.pem", "-key", "/etc/signer/key.pem",
+				"-in", "app.dll", "-out", "app.dll.signed"},
+		},
+		{
+			name: "pkcs11 engine",
+			cfg: config.DecryptedConfig{CertPath: "/etc/signer/cert.pem", Key: "pkcs11:object=codesign;pin-value=1234",
+				Pkcs11Engine: "/usr/lib/engines-3/pkcs11.so", Pkcs11Module: "/usr/lib/vendor-pkcs11.so"},
+			file: "setup.msi",
+			want: []string{"sign", "-h", "sha256", "-pkcs11engine", "/usr/lib/engines-3/pkcs11.so",
+				"-pkcs11module", "/usr/lib/vendor-pkcs11.so", "-certs", "/etc/signer/cert.pem",
+				"-key", "pkcs11:object=codesign;pin-value=1234", "-in", "setup.msi", "-out", "setup.msi.signed"},
+		},
+		{
+			name: "pkcs11 module without engine",
+			cfg: config.DecryptedConfig{CertPath: "/etc/signer/cert.pem", Key: "pkcs11:object=codesign",
+				Pkcs11Module: "/usr/lib/vendor-pkcs11.so"},
+			file: "drivers.cab",
+			want: []string{"sign", "-h", "sha256", "-pkcs11module", "/usr/lib/vendor-pkcs11.so",
+				"-certs", "/etc/signer/cert.pem", "-key", "pkcs11:object=codesign",
+				"-in", "drivers.cab", "-out", "drivers.cab.signed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewOsslSignCode(&tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !s.Capabilities().Supports(tt.file) {
+				t.Errorf("Supports(%s) = false", tt.file)
+			}
+
+			args, cleanup, err := s.signArgs(tt.file, tt.file+".signed")
+			if err != nil {
+				t.Fatalf("signArgs() = %v", err)
+			}
+
+			var passFile string
+			for i, arg := range args {
+				if i > 0 && args[i-1] == "-readpass" {
+					passFile = arg
+					args[i] = "<pass>"
+				}
+			}
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Errorf("signArgs() = %q, want %q", args, tt.want)
+			}
+
+			if tt.password != "" {
+				password, err := os.ReadFile(passFile)
+				if err != nil {
+					t.Fatalf("password file: %v", err)
+				}
+				if string(password) != tt.password {
+					t.Errorf("password file holds %q, want %q", password, tt.password)
+				}
+			}
+			cleanup()
+			if passFile != "" {
+				if _, err := os.Stat(passFile); !os.IsNotExist(err) {
+					t.Errorf("password file %s left behind: %v", passFile, err)
+				}
+			}
+		})
+	}
+}
+
+func TestOsslSignCodeTimestampArgs(t *testing.T) {
+	s, err := NewOsslSignCode(&config.DecryptedConfig{Digest: "sha512"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := s.timestampArgs("http://tsa.example.com", "app.exe.signed", "app.exe.timestamped")
+	want := []string{"add", "-h", "sha512", "-ts", "http://tsa.example.com",
+		"-in", "app.exe.signed", "-out", "app.exe.timestamped"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("timestampArgs() = %q, want %q", args, want)
+	}
+}
+
+// TestOsslSignCodeSign signs the unsigned fixture with the testdata key pair.
+// It runs when osslsigncode is installed.
+func TestOsslSignCodeSign(t *testing.T) {
+	if _, err := exec.LookPath("osslsigncode"); err != nil {
+		t.Skip("osslsigncode is not installed")
+	}
+
+	certPath, err := filepath.Abs(filepath.Join("testdata", "cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath, err := filepath.Abs(filepath.Join("testdata", "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, chain, err := loadKeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hits int32
+	tsa := newTestTSA(t, key, chain[0], time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), &hits)
+
+	s, err := NewOsslSignCode(&config.DecryptedConfig{
+		CertPath:      certPath,
+		Key:           keyPath,
+		TimestampURLs: []string{tsa.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("HealthCheck() = %v", err)
+	}
+
+	unsigned, err := os.ReadFile(filepath.Join("testdata", "unsigned.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "app.exe")
+	if err := os.WriteFile(path, unsigned, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.Sign(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+	if result.TimestampURL != tsa.URL {
+		t.Errorf("TimestampURL = %s, want %s", result.TimestampURL, tsa.URL)
+	}
+
+	signed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := authenticode.Parse(bytes.NewReader(unsigned), int64(len(unsigned)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe, err := authenticode.Parse(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := pe.Signature()
+	if err != nil {
+		t.Fatalf("Signature() = %v", err)
+	}
+	if sig.Thumbprint() != testdataThumbprint {
+		t.Errorf("Thumbprint() = %s, want %s", sig.Thumbprint(), testdataThumbprint)
+	}
+	if sig.Timestamp == nil {
+		t.Error("signature is not timestamped")
+	}
+	if err := authenticode.CompareContent(original, pe); err != nil {
+		t.Errorf("CompareContent() = %v", err)
+	}
+
+	for _, leftover := range []string{path + ".signed", path + ".timestamped"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", leftover, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+)
+
+// peExtensions are the file types the native Authenticode backends sign.
+var peExtensions = []string{".exe", ".dll", ".sys", ".ocx", ".efi", ".scr", ".cpl"}
+
+// signPE signs the PE file at filePath in place with key, whose certificate
+// is the first of chain.
+func signPE(filePath string, key crypto.Signer, chain []*x509.Certificate, opts *authenticode.SignOptions) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	pe, err := authenticode.Parse(file, info.Size())
+	if errors.Is(err, authenticode.ErrNotPE) {
+		return fmt.Errorf("only PE files can be signed by this backend")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid PE file: %v", err)
+	}
+
+	signature, err := pe.Sign(key, chain, opts)
+	if err != nil {
+		return err
+	}
+
+	outPath := filePath + ".signed"
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(outPath)
+
+	err = pe.WriteSigned(out, signature)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write signed file: %v", err)
+	}
+
+	// Windows cannot replace a file that is still open
+	file.Close()
+	if err := os.Rename(outPath, filePath); err != nil {
+		return fmt.Errorf("failed to replace unsigned file: %v", err)
+	}
+
+	return nil
+}
+
+func isPkcs12(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".pfx" || ext == ".p12"
+}
+
+// loadCertificates reads the certificates of a PEM file, signer first, or a
+// single DER certificate.
+func loadCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return []*x509.Certificate{cert}, nil
+}
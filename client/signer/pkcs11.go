@@ -0,0 +1,430 @@
+//go:build cgo
+
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+	"github.com/YHVCorp/signer-service/client/config"
+	"github.com/miekg/pkcs11"
+)
+
+// digestInfoPrefixes are the DER DigestInfo headers prepended to digests for
+// CKM_RSA_PKCS, which signs the DigestInfo as is.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// PKCS11 signs PE files with a key held in any PKCS#11 token or HSM, loading
+// the vendor module directly. The certificate is read from the configured
+// file, or from the token object with the same label as the key.
+type PKCS11 struct {
+	module   string
+	token    string
+	keyLabel string
+	pin      string
+	certPath string
+	tsa      *timestamper
+	settings signSettings
+
+	// The module is loaded for each job and tokens handle one session at a
+	// time
+	mu sync.Mutex
+}
+
+func newPKCS11(cfg *config.DecryptedConfig) (Signer, error) {
+	if cfg.Pkcs11Module == "" {
+		return nil, fmt.Errorf("the pkcs11 backend requires a PKCS#11 module")
+	}
+	if cfg.Pkcs11KeyLabel == "" {
+		return nil, fmt.Errorf("the pkcs11 backend requires a key label")
+	}
+	settings, err := newSignSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PKCS11{
+		module:   cfg.Pkcs11Module,
+		token:    cfg.Pkcs11Token,
+		keyLabel: cfg.Pkcs11KeyLabel,
+		pin:      cfg.Pkcs11Pin,
+		certPath: cfg.CertPath,
+		tsa:      newTimestamper(cfg.TimestampURLs),
+		settings: settings,
+	}, nil
+}
+
+func (s *PKCS11) Sign(ctx context.Context, filePath string) (*Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+	defer session.close()
+
+	key, chain, err := session.signer(s.keyLabel, s.certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	err = signPE(filePath, key, chain, &authenticode.SignOptions{
+		Hash:        s.settings.hash,
+		Timestamp:   s.tsa.authenticode(ctx, s.settings.hash, &result.TimestampURL),
+		Description: s.settings.description,
+		URL:         s.settings.descriptionURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *PKCS11) Capabilities() Capabilities {
+	return Capabilities{
+		Name:           "pkcs11",
+		FileExtensions: peExtensions,
+		Timestamping:   true,
+		MaxConcurrent:  1,
+	}
+}
+
+func (s *PKCS11) HealthCheck(_ context.Context) error {
+	if _, err := os.Stat(s.module); err != nil {
+		return fmt.Errorf("PKCS#11 module not found: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.open()
+	if err != nil {
+		return err
+	}
+	defer session.close()
+
+	_, _, err = session.signer(s.keyLabel, s.certPath)
+	return err
+}
+
+type pkcs11Session struct {
+	ctx    *pkcs11.Ctx
+	handle pkcs11.SessionHandle
+	login  bool
+}
+
+// open loads the module and logs in to the configured token.
+func (s *PKCS11) open() (*pkcs11Session, error) {
+	ctx := pkcs11.New(s.module)
+	if ctx == nil {
+		return nil, fmt.Errorf("failed to load PKCS#11 module %s", s.module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return nil, fmt.Errorf("failed to initialize PKCS#11 module: %v", err)
+	}
+
+	session := &pkcs11Session{ctx: ctx}
+
+	slot, err := s.findSlot(ctx)
+	if err != nil {
+		session.close()
+		return nil, err
+	}
+
+	session.handle, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+	if err != nil {
+		session.close()
+		return nil, fmt.Errorf("failed to open PKCS#11 session: %v", err)
+	}
+
+	if s.pin != "" {
+		err := ctx.Login(session.handle, pkcs11.CKU_USER, s.pin)
+		if err != nil && !errors.Is(err, pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN)) {
+			session.close()
+			return nil, fmt.Errorf("PKCS#11 login failed: %v", err)
+		}
+		session.login = err == nil
+	}
+
+	return session, nil
+}
+
+// findSlot returns the slot holding the token with the configured label. The
+// token may also be given as a slot ID; without one the first token is used.
+func (s *PKCS11) findSlot(ctx *pkcs11.Ctx) (uint, error) {
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list PKCS#11 slots: %v", err)
+	}
+	if len(slots) == 0 {
+		return 0, fmt.Errorf("no PKCS#11 token present")
+	}
+	if s.token == "" {
+		return slots[0], nil
+	}
+
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err == nil && strings.TrimSpace(info.Label) == s.token {
+			return slot, nil
+		}
+	}
+
+	if id, err := strconv.ParseUint(s.token, 10, 0); err == nil {
+		for _, slot := range slots {
+			if slot == uint(id) {
+				return slot, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("PKCS#11 token %q not found", s.token)
+}
+
+func (p *pkcs11Session) close() {
+	if p.login {
+		p.ctx.Logout(p.handle)
+	}
+	if p.handle != 0 {
+		p.ctx.CloseSession(p.handle)
+	}
+	p.ctx.Finalize()
+	p.ctx.Destroy()
+}
+
+// signer returns the private key with the given label and its certificate
+// chain.
+func (p *pkcs11Session) signer(keyLabel, certPath string) (crypto.Signer, []*x509.Certificate, error) {
+	handle, err := p.findObject(pkcs11.CKO_PRIVATE_KEY, keyLabel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var chain []*x509.Certificate
+	if certPath != "" {
+		chain, err = loadCertificates(certPath)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		cert, err := p.certificate(keyLabel)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = []*x509.Certificate{cert}
+	}
+
+	switch chain[0].PublicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, nil, fmt.Errorf("unsupported key type %T", chain[0].PublicKey)
+	}
+
+	// A certificate from the token object has the key's label, one from a
+	// file may belong to another key, and the signatures would only be
+	// rejected when the signed file is verified
+	if certPath != "" {
+		if err := p.checkCertificateKey(handle, keyLabel, chain[0].PublicKey); err != nil {
+			return nil, nil, fmt.Errorf("certificate %s: %v", certPath, err)
+		}
+	}
+
+	return &pkcs11Key{session: p, handle: handle, public: chain[0].PublicKey}, chain, nil
+}
+
+// checkCertificateKey compares the public key of the certificate with the key
+// on the token. The public attributes are read from the private key object,
+// or from the public key object with the same label for tokens that do not
+// expose them there, as is usual for EC keys.
+func (p *pkcs11Session) checkCertificateKey(keyHandle pkcs11.ObjectHandle, keyLabel string, public crypto.PublicKey) error {
+	var types []uint
+	switch public.(type) {
+	case *rsa.PublicKey:
+		types = []uint{pkcs11.CKA_MODULUS, pkcs11.CKA_PUBLIC_EXPONENT}
+	case *ecdsa.PublicKey:
+		types = []uint{pkcs11.CKA_EC_POINT}
+	}
+
+	attrs, err := p.attributes(keyHandle, types)
+	if err != nil {
+		publicHandle, findErr := p.findObject(pkcs11.CKO_PUBLIC_KEY, keyLabel)
+		if findErr != nil {
+			return fmt.Errorf("cannot read the public key of %q from the token: %v", keyLabel, err)
+		}
+		if attrs, err = p.attributes(publicHandle, types); err != nil {
+			return fmt.Errorf("cannot read the public key of %q from the token: %v", keyLabel, err)
+		}
+	}
+
+	match, err := publicKeyMatches(public, attrs)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return fmt.Errorf("does not match the key %q on the token", keyLabel)
+	}
+	return nil
+}
+
+// attributes reads the given attributes of an object, failing when any of
+// them is missing or empty.
+func (p *pkcs11Session) attributes(handle pkcs11.ObjectHandle, types []uint) (map[uint][]byte, error) {
+	template := make([]*pkcs11.Attribute, len(types))
+	for i, t := range types {
+		template[i] = pkcs11.NewAttribute(t, nil)
+	}
+	attrs, err := p.ctx.GetAttributeValue(p.handle, handle, template)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[uint][]byte, len(attrs))
+	for _, attr := range attrs {
+		values[attr.Type] = attr.Value
+	}
+	for _, t := range types {
+		if len(values[t]) == 0 {
+			return nil, fmt.Errorf("attribute %#x not available", t)
+		}
+	}
+	return values, nil
+}
+
+// publicKeyMatches reports whether the token attributes, CKA_MODULUS and
+// CKA_PUBLIC_EXPONENT for RSA keys or CKA_EC_POINT for EC keys, describe
+// public.
+func publicKeyMatches(public crypto.PublicKey, attrs map[uint][]byte) (bool, error) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		modulus := new(big.Int).SetBytes(attrs[pkcs11.CKA_MODULUS])
+		exponent := new(big.Int).SetBytes(attrs[pkcs11.CKA_PUBLIC_EXPONENT])
+		return modulus.Cmp(pub.N) == 0 && exponent.Cmp(big.NewInt(int64(pub.E))) == 0, nil
+
+	case *ecdsa.PublicKey:
+		key, err := pub.ECDH()
+		if err != nil {
+			return false, fmt.Errorf("unsupported EC key: %v", err)
+		}
+		// The point is DER encoded as an OCTET STRING, which some tokens
+		// leave out
+		point := attrs[pkcs11.CKA_EC_POINT]
+		if bytes.Equal(point, key.Bytes()) {
+			return true, nil
+		}
+		var octets []byte
+		rest, err := asn1.Unmarshal(point, &octets)
+		return err == nil && len(rest) == 0 && bytes.Equal(octets, key.Bytes()), nil
+	}
+
+	return false, fmt.Errorf("unsupported key type %T", public)
+}
+
+func (p *pkcs11Session) findObject(class uint, label string) (pkcs11.ObjectHandle, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err := p.ctx.FindObjectsInit(p.handle, template); err != nil {
+		return 0, fmt.Errorf("PKCS#11 object search failed: %v", err)
+	}
+	objects, _, err := p.ctx.FindObjects(p.handle, 1)
+	p.ctx.FindObjectsFinal(p.handle)
+	if err != nil {
+		return 0, fmt.Errorf("PKCS#11 object search failed: %v", err)
+	}
+	if len(objects) == 0 {
+		return 0, fmt.Errorf("PKCS#11 object %q not found on the token", label)
+	}
+	return objects[0], nil
+}
+
+func (p *pkcs11Session) certificate(label string) (*x509.Certificate, error) {
+	handle, err := p.findObject(pkcs11.CKO_CERTIFICATE, label)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := p.ctx.GetAttributeValue(p.handle, handle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil),
+	})
+	if err != nil || len(attrs) == 0 {
+		return nil, fmt.Errorf("failed to read certificate %q from the token: %v", label, err)
+	}
+
+	return x509.ParseCertificate(attrs[0].Value)
+}
+
+// pkcs11Key is a crypto.Signer for a private key of an open session.
+type pkcs11Key struct {
+	session *pkcs11Session
+	handle  pkcs11.ObjectHandle
+	public  crypto.PublicKey
+}
+
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.public
+}
+
+func (k *pkcs11Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	ctx := k.session.ctx
+	session := k.session.handle
+
+	switch k.public.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("RSA-PSS signatures are not supported")
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported digest algorithm %v", opts.HashFunc())
+		}
+
+		mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)}
+		if err := ctx.SignInit(session, mechanism, k.handle); err != nil {
+			return nil, err
+		}
+		return ctx.Sign(session, append(append([]byte{}, prefix...), digest...))
+
+	case *ecdsa.PublicKey:
+		mechanism := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}
+		if err := ctx.SignInit(session, mechanism, k.handle); err != nil {
+			return nil, err
+		}
+		raw, err := ctx.Sign(session, digest)
+		if err != nil {
+			return nil, err
+		}
+
+		// Tokens return r and s concatenated, X.509 wants them DER encoded
+		half := len(raw) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(raw[:half]),
+			S: new(big.Int).SetBytes(raw[half:]),
+		})
+	}
+
+	return nil, fmt.Errorf("unsupported key type %T", k.public)
+}
@@ -0,0 +1,12 @@
+//go:build !cgo
+
+package signer
+
+import (
+	"github.com/YHVCorp/signer-service/client/config"
+)
+
+// The PKCS#11 backend loads vendor modules through cgo.
+func newPKCS11(_ *config.DecryptedConfig) (Signer, error) {
+	return nil, config.ErrPKCS11Unavailable
+}
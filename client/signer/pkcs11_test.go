@@ -0,0 +1,332 @@
+//go:build cgo
+
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+	"github.com/YHVCorp/signer-service/client/config"
+	"github.com/miekg/pkcs11"
+)
+
+const (
+	softHSMToken = "signer-test"
+	softHSMLabel = "codesign"
+	softHSMPin   = "1234"
+)
+
+// TestPKCS11SoftHSM signs the unsigned fixture with the testdata key imported
+// in a SoftHSM token. It runs when SOFTHSM2_CONF is set and SOFTHSM2_MODULE
+// names the SoftHSM library, e.g. /usr/lib/softhsm/libsofthsm2.so. The token
+// is created in a temporary token directory, leaving the configured tokens
+// alone.
+func TestPKCS11SoftHSM(t *testing.T) {
+	module := os.Getenv("SOFTHSM2_MODULE")
+	if os.Getenv("SOFTHSM2_CONF") == "" || module == "" {
+		t.Skip("SOFTHSM2_CONF and SOFTHSM2_MODULE are not set")
+	}
+
+	tokenDir := t.TempDir()
+	conf := filepath.Join(t.TempDir(), "softhsm2.conf")
+	if err := os.WriteFile(conf, []byte("directories.tokendir = "+tokenDir+"\nobjectstore.backend = file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOFTHSM2_CONF", conf)
+
+	key, chain, err := loadKeyPair(filepath.Join("testdata", "cert.pem"), filepath.Join("testdata", "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		t.Fatalf("testdata key is a %T, want an RSA key", key)
+	}
+	importSoftHSMKey(t, module, rsaKey, chain[0].Raw)
+
+	var hits int32
+	tsa := newTestTSA(t, key, chain[0], time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), &hits)
+
+	s, err := newPKCS11(&config.DecryptedConfig{
+		Backend:        "pkcs11",
+		Pkcs11Module:   module,
+		Pkcs11Token:    softHSMToken,
+		Pkcs11KeyLabel: softHSMLabel,
+		Pkcs11Pin:      softHSMPin,
+		TimestampURLs:  []string{tsa.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HealthCheck(context.Background()); err != nil {
+		t.Fatalf("HealthCheck() = %v", err)
+	}
+
+	unsigned, err := os.ReadFile(filepath.Join("testdata", "unsigned.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "app.exe")
+	if err := os.WriteFile(path, unsigned, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.Sign(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+	if result.TimestampURL != tsa.URL {
+		t.Errorf("TimestampURL = %s, want %s", result.TimestampURL, tsa.URL)
+	}
+
+	signed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := authenticode.Parse(bytes.NewReader(unsigned), int64(len(unsigned)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe, err := authenticode.Parse(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := pe.Signature()
+	if err != nil {
+		t.Fatalf("Signature() = %v", err)
+	}
+	// The certificate is read from the token object with the key label
+	if sig.Thumbprint() != testdataThumbprint {
+		t.Errorf("Thumbprint() = %s, want %s", sig.Thumbprint(), testdataThumbprint)
+	}
+	if sig.Timestamp == nil {
+		t.Error("signature is not timestamped")
+	}
+	if err := authenticode.CompareContent(original, pe); err != nil {
+		t.Errorf("CompareContent() = %v", err)
+	}
+
+	// A certificate file must belong to the key on the token
+	for _, tt := range []struct {
+		name     string
+		certPath string
+		wantErr  string
+	}{
+		{"matching", filepath.Join("testdata", "cert.pem"), ""},
+		{"other key", writeTestCertificate(t), "does not match the key"},
+	} {
+		s, err := newPKCS11(&config.DecryptedConfig{
+			Backend:        "pkcs11",
+			Pkcs11Module:   module,
+			Pkcs11Token:    softHSMToken,
+			Pkcs11KeyLabel: softHSMLabel,
+			Pkcs11Pin:      softHSMPin,
+			CertPath:       tt.certPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.HealthCheck(context.Background())
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: HealthCheck() = %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: HealthCheck() = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPublicKeyMatches(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherRSA, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherEC, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaAttrs := func(key *rsa.PrivateKey) map[uint][]byte {
+		return map[uint][]byte{
+			pkcs11.CKA_MODULUS:         key.N.Bytes(),
+			pkcs11.CKA_PUBLIC_EXPONENT: big.NewInt(int64(key.E)).Bytes(),
+		}
+	}
+	point := func(key *ecdsa.PrivateKey) []byte {
+		public, err := key.PublicKey.ECDH()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return public.Bytes()
+	}
+	der, err := asn1.Marshal(point(ecKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		attrs map[uint][]byte
+		want  bool
+	}{
+		{"rsa", rsaAttrs(rsaKey), true},
+		{"rsa other key", rsaAttrs(otherRSA), false},
+		{"ec der point", map[uint][]byte{pkcs11.CKA_EC_POINT: der}, true},
+		{"ec raw point", map[uint][]byte{pkcs11.CKA_EC_POINT: point(ecKey)}, true},
+		{"ec other key", map[uint][]byte{pkcs11.CKA_EC_POINT: point(otherEC)}, false},
+	}
+
+	for _, tt := range tests {
+		var public any = &rsaKey.PublicKey
+		if strings.HasPrefix(tt.name, "ec") {
+			public = &ecKey.PublicKey
+		}
+		got, err := publicKeyMatches(public, tt.attrs)
+		if err != nil {
+			t.Fatalf("%s: publicKeyMatches() = %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: publicKeyMatches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for a new key and
+// returns its path.
+func writeTestCertificate(t *testing.T) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Other Key"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "other.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// importSoftHSMKey initializes a token and stores the private key and its
+// certificate under the test label.
+func importSoftHSMKey(t *testing.T, module string, key *rsa.PrivateKey, cert []byte) {
+	t.Helper()
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		t.Fatalf("failed to load %s", module)
+	}
+	defer ctx.Destroy()
+	if err := ctx.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Finalize()
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil || len(slots) == 0 {
+		t.Fatalf("no SoftHSM slot: %v", err)
+	}
+	if err := ctx.InitToken(slots[0], "5678", softHSMToken); err != nil {
+		t.Fatalf("InitToken() = %v", err)
+	}
+
+	// SoftHSM moves the new token to another slot
+	slots, err = ctx.GetSlotList(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot, found := uint(0), false
+	for _, s := range slots {
+		if info, err := ctx.GetTokenInfo(s); err == nil && info.Flags&pkcs11.CKF_TOKEN_INITIALIZED != 0 {
+			slot, found = s, true
+			break
+		}
+	}
+	if !found {
+		t.Fatal("initialized token not found")
+	}
+
+	session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.CloseSession(session)
+
+	if err := ctx.Login(session, pkcs11.CKU_SO, "5678"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.InitPIN(session, softHSMPin); err != nil {
+		t.Fatal(err)
+	}
+	ctx.Logout(session)
+	if err := ctx.Login(session, pkcs11.CKU_USER, softHSMPin); err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Logout(session)
+
+	key.Precompute()
+	_, err = ctx.CreateObject(session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, softHSMLabel),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, key.N.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, big.NewInt(int64(key.E)).Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE_EXPONENT, key.D.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME_1, key.Primes[0].Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIME_2, key.Primes[1].Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_1, key.Precomputed.Dp.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_EXPONENT_2, key.Precomputed.Dq.Bytes()),
+		pkcs11.NewAttribute(pkcs11.CKA_COEFFICIENT, key.Precomputed.Qinv.Bytes()),
+	})
+	if err != nil {
+		t.Fatalf("failed to import the private key: %v", err)
+	}
+
+	_, err = ctx.CreateObject(session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_CERTIFICATE),
+		pkcs11.NewAttribute(pkcs11.CKA_CERTIFICATE_TYPE, pkcs11.CKC_X_509),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, softHSMLabel),
+		pkcs11.NewAttribute(pkcs11.CKA_VALUE, cert),
+	})
+	if err != nil {
+		t.Fatalf("failed to import the certificate: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/YHVCorp/signer-service/client/config"
+)
+
+// DefaultBackend is used when the client config does not name a backend.
+const DefaultBackend = "signtool"
+
+// Signer signs files in place with one signing backend.
+type Signer interface {
+	// Sign replaces the file at filePath with its signed version.
+	Sign(ctx context.Context, filePath string) (*Result, error)
+	// Capabilities describes what the backend can sign.
+	Capabilities() Capabilities
+	// HealthCheck reports whether the backend is usable on this machine.
+	HealthCheck(ctx context.Context) error
+}
+
+// Result describes a signature made by a backend.
+type Result struct {
+	// TimestampURL is the timestamp authority that timestamped the
+	// signature, empty when it was not timestamped.
+	TimestampURL string
+}
+
+// Capabilities describes a signing backend.
+type Capabilities struct {
+	Name string
+	// FileExtensions lists the extensions the backend can sign, lower case
+	// with the leading dot. An empty list accepts any file.
+	FileExtensions []string
+	// Timestamping is true when signatures are timestamped by a TSA.
+	Timestamping bool
+	// MaxConcurrent is the number of files the backend can sign at the same
+	// time, 0 when there is no limit.
+	MaxConcurrent int
+}
+
+// Supports reports whether a file with the given name can be signed.
+func (c Capabilities) Supports(fileName string) bool {
+	if len(c.FileExtensions) == 0 {
+		return true
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, supported := range c.FileExtensions {
+		if ext == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// New returns the backend selected by the client config.
+func New(cfg *config.DecryptedConfig) (Signer, error) {
+	backend := cfg.Backend
+	if backend == "" {
+		backend = DefaultBackend
+	}
+
+	switch backend {
+	case "signtool":
+		return NewSignTool(cfg)
+	case "osslsigncode":
+		return NewOsslSignCode(cfg)
+	case "pkcs11":
+		return newPKCS11(cfg)
+	case "native":
+		return newNativeFromConfig(cfg)
+	default:
+		return nil, fmt.Errorf("unknown signing backend: %s", backend)
+	}
+}
+
+// signSettings are the signature settings of the client config shared by all
+// backends.
+type signSettings struct {
+	hash           crypto.Hash
+	description    string
+	descriptionURL string
+}
+
+func newSignSettings(cfg *config.DecryptedConfig) (signSettings, error) {
+	settings := signSettings{
+		description:    cfg.Description,
+		descriptionURL: cfg.DescriptionURL,
+	}
+
+	switch strings.ToLower(cfg.Digest) {
+	case "", "sha256":
+		settings.hash = crypto.SHA256
+	case "sha384":
+		settings.hash = crypto.SHA384
+	case "sha512":
+		settings.hash = crypto.SHA512
+	case "sha1":
+		settings.hash = crypto.SHA1
+	default:
+		return settings, fmt.Errorf("unsupported digest algorithm: %s", cfg.Digest)
+	}
+
+	return settings, nil
+}
+
+// digestName returns the lower case name of the digest algorithm, as used
+// on the command line of the signing tools.
+func (s signSettings) digestName() string {
+	return strings.ToLower(strings.ReplaceAll(s.hash.String(), "-", ""))
+}
@@ -0,0 +1,123 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/YHVCorp/signer-service/client/config"
+	"github.com/YHVCorp/signer-service/client/utils"
+)
+
+const defaultCSP = "eToken Base Cryptographic Provider"
+
+// SignTool signs files with Microsoft signtool, using a key held in a
+// hardware token through its cryptographic service provider.
+type SignTool struct {
+	certPath  string
+	key       string
+	container string
+	csp       string
+	tsa       *timestamper
+	settings  signSettings
+}
+
+func NewSignTool(cfg *config.DecryptedConfig) (*SignTool, error) {
+	settings, err := newSignSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	csp := cfg.CSP
+	if csp == "" {
+		csp = defaultCSP
+	}
+
+	return &SignTool{
+		certPath:  cfg.CertPath,
+		key:       cfg.Key,
+		container: cfg.Container,
+		csp:       csp,
+		tsa:       newTimestamper(cfg.TimestampURLs),
+		settings:  settings,
+	}, nil
+}
+
+// Sign signs the file and then timestamps it in a second signtool call, so a
+// timestamp authority that is down is replaced by the next one without
+// signing again with the token.
+func (s *SignTool) Sign(ctx context.Context, filePath string) (*Result, error) {
+	if runtime.GOOS != "windows" {
+		return nil, fmt.Errorf("signtool is only supported on Windows")
+	}
+
+	// Construct the command to execute signtool
+	// Example command:
+	// signtool sign /fd SHA256 /f "<CERT>" /csp "eToken Base Cryptographic Provider" /k "[{{<KEY>}}]=<CONTAINER>" "<FILE_TO_SIGN>"
+	digest := strings.ToUpper(s.settings.digestName())
+	args := []string{
+		"sign",
+		"/fd", digest,
+		"/f", s.certPath,
+		"/csp", s.csp,
+		"/k", fmt.Sprintf("[{{%s}}]=%s", s.key, s.container),
+	}
+	if s.settings.description != "" {
+		args = append(args, "/d", s.settings.description)
+	}
+	if s.settings.descriptionURL != "" {
+		args = append(args, "/du", s.settings.descriptionURL)
+	}
+	args = append(args, filePath)
+
+	output, err := utils.ExecuteContext(ctx, "signtool", utils.GetMyPath(), args...)
+	if err != nil {
+		return nil, fmt.Errorf("signtool failed: %v: %s", err, strings.TrimSpace(output))
+	}
+
+	// signtool timestamp /tr http://timestamp.digicert.com /td SHA256 "<FILE_TO_SIGN>"
+	url, err := s.tsa.try(ctx, func(url string) error {
+		output, err := utils.ExecuteContext(ctx, "signtool", utils.GetMyPath(),
+			"timestamp", "/tr", url, "/td", digest, filePath)
+		if err != nil {
+			return fmt.Errorf("%v: %s", err, strings.TrimSpace(output))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{TimestampURL: url}, nil
+}
+
+func (s *SignTool) Capabilities() Capabilities {
+	return Capabilities{
+		Name: "signtool",
+		FileExtensions: []string{
+			".exe", ".dll", ".sys", ".msi", ".msix", ".appx", ".cab", ".cat", ".ocx", ".ps1",
+		},
+		Timestamping: true,
+		// The token CSP does not handle parallel signing sessions
+		MaxConcurrent: 1,
+	}
+}
+
+func (s *SignTool) HealthCheck(_ context.Context) error {
+	if runtime.GOOS != "windows" {
+		return fmt.Errorf("signtool is only supported on Windows")
+	}
+
+	if _, err := exec.LookPath("signtool"); err != nil {
+		return fmt.Errorf("signtool not found in PATH: %v", err)
+	}
+
+	if _, err := os.Stat(s.certPath); err != nil {
+		return fmt.Errorf("certificate not found: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package signer
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+	"github.com/YHVCorp/signer-service/client/utils"
+)
+
+const (
+	timestampTimeout = 30 * time.Second
+	// timestampRounds is the number of passes over the authority list before
+	// a job fails
+	timestampRounds     = 2
+	timestampRetryDelay = 5 * time.Second
+)
+
+// DefaultTimestampURLs are the RFC 3161 timestamp authorities used when the
+// client config does not list any, in order of preference.
+var DefaultTimestampURLs = []string{
+	"http://timestamp.digicert.com",
+	"http://timestamp.sectigo.com",
+}
+
+// timestamper tries the timestamp authorities in order until one answers.
+type timestamper struct {
+	urls       []string
+	retryDelay time.Duration
+}
+
+func newTimestamper(urls []string) *timestamper {
+	if len(urls) == 0 {
+		urls = DefaultTimestampURLs
+	}
+	return &timestamper{urls: urls, retryDelay: timestampRetryDelay}
+}
+
+// try calls attempt with each authority URL in order and returns the URL of
+// the first attempt that succeeds. When every authority fails, the list is
+// tried again after a pause.
+func (t *timestamper) try(ctx context.Context, attempt func(url string) error) (string, error) {
+	var errs []string
+	for round := 0; round < timestampRounds; round++ {
+		if round > 0 {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(t.retryDelay):
+			}
+		}
+
+		errs = errs[:0]
+		for _, url := range t.urls {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+
+			err := attempt(url)
+			if err == nil {
+				return url, nil
+			}
+
+			utils.Logger.ErrorF("Timestamp authority %s failed: %v", url, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+		}
+	}
+
+	return "", fmt.Errorf("all timestamp authorities failed: %s", strings.Join(errs, "; "))
+}
+
+// authenticode returns the SignOptions.Timestamp function for the native
+// backends. The URL of the authority that answered is stored in used.
+func (t *timestamper) authenticode(ctx context.Context, h crypto.Hash, used *string) func([]byte) ([]byte, error) {
+	client := &http.Client{Timeout: timestampTimeout}
+	return func(signature []byte) ([]byte, error) {
+		var token []byte
+		url, err := t.try(ctx, func(url string) error {
+			var err error
+			token, err = authenticode.RequestTimestamp(ctx, client, url, h, signature)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		*used = url
+		return token, nil
+	}
+}
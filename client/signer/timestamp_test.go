@@ -0,0 +1,306 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+)
+
+var (
+	oidTestSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTestTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidTestSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidTestRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidTestPolicy     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}
+)
+
+type testMessageImprint struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type testTimestampRequest struct {
+	Version        int
+	MessageImprint testMessageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type testTSTInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint testMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+type testContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type testIssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type testSignerInfo struct {
+	Version               int
+	IssuerAndSerialNumber testIssuerAndSerial
+	DigestAlgorithm       pkix.AlgorithmIdentifier
+	SignatureAlgorithm    pkix.AlgorithmIdentifier
+	Signature             []byte
+}
+
+type testSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      testContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []testSignerInfo `asn1:"set"`
+}
+
+type testPKIStatus struct {
+	Status int
+}
+
+type testTimestampResponse struct {
+	Status         testPKIStatus
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// newTestTSA starts an RFC 3161 authority answering every request with a
+// token for genTime signed by key. hits counts the requests.
+func newTestTSA(t *testing.T, key crypto.Signer, cert *x509.Certificate, genTime time.Time, hits *int32) *httptest.Server {
+	t.Helper()
+
+	explicit := func(content []byte) asn1.RawValue {
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}
+	}
+	sha256Algorithm := pkix.AlgorithmIdentifier{Algorithm: oidTestSHA256, Parameters: asn1.NullRawValue}
+
+	tsa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+
+		body, _ := io.ReadAll(r.Body)
+		var req testTimestampRequest
+		if _, err := asn1.Unmarshal(body, &req); err != nil || r.Header.Get("Content-Type") != "application/timestamp-query" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		tstInfo, err := asn1.Marshal(testTSTInfo{
+			Version:        1,
+			Policy:         oidTestPolicy,
+			MessageImprint: req.MessageImprint,
+			SerialNumber:   big.NewInt(1),
+			GenTime:        genTime,
+			Nonce:          req.Nonce,
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		content, _ := asn1.Marshal(tstInfo)
+		digest := crypto.SHA256.New()
+		digest.Write(tstInfo)
+		signature, err := key.Sign(rand.Reader, digest.Sum(nil), crypto.SHA256)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		sd, err := asn1.Marshal(testSignedData{
+			Version:          3,
+			DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Algorithm},
+			ContentInfo:      testContentInfo{ContentType: oidTestTSTInfo, Content: explicit(content)},
+			Certificates:     explicit(cert.Raw),
+			SignerInfos: []testSignerInfo{{
+				Version: 1,
+				IssuerAndSerialNumber: testIssuerAndSerial{
+					Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+					SerialNumber: cert.SerialNumber,
+				},
+				DigestAlgorithm:    sha256Algorithm,
+				SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidTestRSA, Parameters: asn1.NullRawValue},
+				Signature:          signature,
+			}},
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		token, _ := asn1.Marshal(testContentInfo{ContentType: oidTestSignedData, Content: explicit(sd)})
+		reply, _ := asn1.Marshal(testTimestampResponse{TimeStampToken: asn1.RawValue{FullBytes: token}})
+
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(reply)
+	}))
+	t.Cleanup(tsa.Close)
+	return tsa
+}
+
+// newFailingTSA starts an authority answering every request with handler.
+func newFailingTSA(t *testing.T, hits *int32, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	tsa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(hits, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(tsa.Close)
+	return tsa
+}
+
+func unavailable(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, "unavailable", http.StatusInternalServerError)
+}
+
+func rejected(w http.ResponseWriter, _ *http.Request) {
+	// PKIStatus rejection
+	reply, _ := asn1.Marshal(testTimestampResponse{Status: testPKIStatus{Status: 2}})
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(reply)
+}
+
+// newTimestampTestSigner returns a native backend using the testdata key pair
+// and the authorities at urls, and the path of an unsigned file to sign.
+func newTimestampTestSigner(t *testing.T, urls ...string) (*Native, string) {
+	t.Helper()
+
+	key, chain, err := loadKeyPair(filepath.Join("testdata", "cert.pem"), filepath.Join("testdata", "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned, err := os.ReadFile(filepath.Join("testdata", "unsigned.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "app.exe")
+	if err := os.WriteFile(path, unsigned, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewNative(key, chain, NativeOptions{TimestampURLs: urls})
+	s.tsa.retryDelay = 10 * time.Millisecond
+	return s, path
+}
+
+func TestTimestampFailover(t *testing.T) {
+	var downHits, goodHits, spareHits int32
+	genTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	key, chain, err := loadKeyPair(filepath.Join("testdata", "cert.pem"), filepath.Join("testdata", "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := newFailingTSA(t, &downHits, unavailable)
+	good := newTestTSA(t, key, chain[0], genTime, &goodHits)
+	spare := newTestTSA(t, key, chain[0], genTime, &spareHits)
+
+	s, path := newTimestampTestSigner(t, down.URL, good.URL, spare.URL)
+	result, err := s.Sign(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	if result.TimestampURL != good.URL {
+		t.Errorf("TimestampURL = %s, want %s", result.TimestampURL, good.URL)
+	}
+	// Authorities are tried in order and the first answer is kept
+	if downHits != 1 || goodHits != 1 || spareHits != 0 {
+		t.Errorf("authorities called %d, %d and %d times, want 1, 1 and 0", downHits, goodHits, spareHits)
+	}
+
+	signed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe, err := authenticode.Parse(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := pe.Signature()
+	if err != nil {
+		t.Fatalf("Signature() = %v", err)
+	}
+	if sig.Timestamp == nil || !sig.Timestamp.RFC3161 || !sig.Timestamp.Time.Equal(genTime) {
+		t.Fatalf("Timestamp = %+v, want an RFC 3161 timestamp at %s", sig.Timestamp, genTime)
+	}
+	if authenticode.Thumbprint(sig.Timestamp.Signer) != testdataThumbprint {
+		t.Errorf("timestamp signed by %s, want the test authority", authenticode.Thumbprint(sig.Timestamp.Signer))
+	}
+}
+
+func TestTimestampAllAuthoritiesFail(t *testing.T) {
+	var downHits, rejectedHits int32
+	down := newFailingTSA(t, &downHits, unavailable)
+	rejecting := newFailingTSA(t, &rejectedHits, rejected)
+
+	s, path := newTimestampTestSigner(t, down.URL, rejecting.URL)
+	unsigned, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Sign(context.Background(), path)
+	if err == nil {
+		t.Fatal("Sign() succeeded without a timestamp")
+	}
+
+	// Every authority is named with its own error
+	for _, want := range []string{
+		"all timestamp authorities failed",
+		down.URL + ": unexpected status 500",
+		rejecting.URL + ": timestamp request rejected with status 2",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if downHits != timestampRounds || rejectedHits != timestampRounds {
+		t.Errorf("authorities called %d and %d times, want %d each", downHits, rejectedHits, timestampRounds)
+	}
+
+	// The file is left unsigned
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(current, unsigned) {
+		t.Error("file modified after a failed timestamp")
+	}
+}
+
+func TestTimestampStopsWhenCancelled(t *testing.T) {
+	var hits int32
+	ctx, cancel := context.WithCancel(context.Background())
+	down := newFailingTSA(t, &hits, func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		unavailable(w, r)
+	})
+
+	s, path := newTimestampTestSigner(t, down.URL, down.URL)
+	if _, err := s.Sign(ctx, path); err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("Sign() = %v, want %v", err, context.Canceled)
+	}
+	if hits != 1 {
+		t.Errorf("authority called %d times after the job was cancelled, want 1", hits)
+	}
+}
@@ -1,9 +1,8 @@
 package utils
 
 import (
-	"fmt"
+	"context"
 	"os/exec"
-	"runtime"
 )
 
 func Execute(c string, dir string, arg ...string) error {
@@ -13,29 +12,12 @@ func Execute(c string, dir string, arg ...string) error {
 	return cmd.Run()
 }
 
-func ExecuteSignTool(certPath, key, container, filePath string) error {
-	if runtime.GOOS != "windows" {
-		return fmt.Errorf("signtool is only supported on Windows")
-	}
-
-	// Construct the command to execute signtool
-	// Example command:
-	// signtool sign /fd SHA256 /tr http://timestamp.digicert.com /td SHA256 /f "<CERT>" /csp "eToken Base Cryptographic Provider" /k "[{{<KEY>}}]=<CONTAINER>" "<FILE_TO_SIGN>"
-	args := []string{
-		"sign",
-		"/fd", "SHA256",
-		"/tr", "http://timestamp.digicert.com",
-		"/td", "SHA256",
-		"/f", certPath,
-		"/csp", "eToken Base Cryptographic Provider",
-		"/k", fmt.Sprintf("[{{%s}}]=%s", key, container),
-		filePath,
-	}
-
-	err := Execute("signtool", GetMyPath(), args...)
-	if err != nil {
-		return fmt.Errorf("signtool failed: %v", err)
-	}
+// ExecuteContext runs a command that is killed when ctx is cancelled and
+// returns its combined output, which signing tools use to explain failures.
+func ExecuteContext(ctx context.Context, c string, dir string, arg ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, c, arg...)
+	cmd.Dir = dir
 
-	return nil
+	output, err := cmd.CombinedOutput()
+	return string(output), err
 }
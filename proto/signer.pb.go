@@ -21,6 +21,119 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type JobPhase int32
+
+const (
+	JobPhase_JOB_PHASE_UNSPECIFIED  JobPhase = 0
+	JobPhase_JOB_PHASE_ACKNOWLEDGED JobPhase = 1
+	JobPhase_JOB_PHASE_DOWNLOADING  JobPhase = 2
+	JobPhase_JOB_PHASE_SIGNING      JobPhase = 3
+	JobPhase_JOB_PHASE_TIMESTAMPING JobPhase = 4
+	JobPhase_JOB_PHASE_UPLOADING    JobPhase = 5
+	JobPhase_JOB_PHASE_COMPLETED    JobPhase = 6
+	JobPhase_JOB_PHASE_FAILED       JobPhase = 7
+)
+
+// Enum value maps for JobPhase.
+var (
+	JobPhase_name = map[int32]string{
+		0: "JOB_PHASE_UNSPECIFIED",
+		1: "JOB_PHASE_ACKNOWLEDGED",
+		2: "JOB_PHASE_DOWNLOADING",
+		3: "JOB_PHASE_SIGNING",
+		4: "JOB_PHASE_TIMESTAMPING",
+		5: "JOB_PHASE_UPLOADING",
+		6: "JOB_PHASE_COMPLETED",
+		7: "JOB_PHASE_FAILED",
+	}
+	JobPhase_value = map[string]int32{
+		"JOB_PHASE_UNSPECIFIED":  0,
+		"JOB_PHASE_ACKNOWLEDGED": 1,
+		"JOB_PHASE_DOWNLOADING":  2,
+		"JOB_PHASE_SIGNING":      3,
+		"JOB_PHASE_TIMESTAMPING": 4,
+		"JOB_PHASE_UPLOADING":    5,
+		"JOB_PHASE_COMPLETED":    6,
+		"JOB_PHASE_FAILED":       7,
+	}
+)
+
+func (x JobPhase) Enum() *JobPhase {
+	p := new(JobPhase)
+	*p = x
+	return p
+}
+
+func (x JobPhase) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (JobPhase) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_signer_proto_enumTypes[0].Descriptor()
+}
+
+func (JobPhase) Type() protoreflect.EnumType {
+	return &file_proto_signer_proto_enumTypes[0]
+}
+
+func (x JobPhase) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use JobPhase.Descriptor instead.
+func (JobPhase) EnumDescriptor() ([]byte, []int) {
+	return file_proto_signer_proto_rawDescGZIP(), []int{0}
+}
+
+type CommandType int32
+
+const (
+	CommandType_COMMAND_TYPE_UNSPECIFIED CommandType = 0
+	CommandType_COMMAND_TYPE_SIGN        CommandType = 1
+	CommandType_COMMAND_TYPE_CANCEL      CommandType = 2
+)
+
+// Enum value maps for CommandType.
+var (
+	CommandType_name = map[int32]string{
+		0: "COMMAND_TYPE_UNSPECIFIED",
+		1: "COMMAND_TYPE_SIGN",
+		2: "COMMAND_TYPE_CANCEL",
+	}
+	CommandType_value = map[string]int32{
+		"COMMAND_TYPE_UNSPECIFIED": 0,
+		"COMMAND_TYPE_SIGN":        1,
+		"COMMAND_TYPE_CANCEL":      2,
+	}
+)
+
+func (x CommandType) Enum() *CommandType {
+	p := new(CommandType)
+	*p = x
+	return p
+}
+
+func (x CommandType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (CommandType) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_signer_proto_enumTypes[1].Descriptor()
+}
+
+func (CommandType) Type() protoreflect.EnumType {
+	return &file_proto_signer_proto_enumTypes[1]
+}
+
+func (x CommandType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use CommandType.Descriptor instead.
+func (CommandType) EnumDescriptor() ([]byte, []int) {
+	return file_proto_signer_proto_rawDescGZIP(), []int{1}
+}
+
 type Empty struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
@@ -63,6 +176,8 @@ type SignRequest struct {
 	FileName      string                 `protobuf:"bytes,2,opt,name=file_name,json=fileName,proto3" json:"file_name,omitempty"`
 	DownloadUrl   string                 `protobuf:"bytes,3,opt,name=download_url,json=downloadUrl,proto3" json:"download_url,omitempty"`
 	UploadUrl     string                 `protobuf:"bytes,4,opt,name=upload_url,json=uploadUrl,proto3" json:"upload_url,omitempty"`
+	Sha256        string                 `protobuf:"bytes,5,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	Profile       string                 `protobuf:"bytes,6,opt,name=profile,proto3" json:"profile,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -125,6 +240,20 @@ func (x *SignRequest) GetUploadUrl() string {
 	return ""
 }
 
+func (x *SignRequest) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+func (x *SignRequest) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
 type SignResult struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	RequestId     string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
@@ -185,28 +314,411 @@ func (x *SignResult) GetMessage() string {
 	return ""
 }
 
+type FileRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RequestId     string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileRequest) Reset() {
+	*x = FileRequest{}
+	mi := &file_proto_signer_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileRequest) ProtoMessage() {}
+
+func (x *FileRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_signer_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileRequest.ProtoReflect.Descriptor instead.
+func (*FileRequest) Descriptor() ([]byte, []int) {
+	return file_proto_signer_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *FileRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+type FileChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RequestId     string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	Crc32         uint32                 `protobuf:"varint,4,opt,name=crc32,proto3" json:"crc32,omitempty"`
+	TotalSize     int64                  `protobuf:"varint,5,opt,name=total_size,json=totalSize,proto3" json:"total_size,omitempty"`
+	Sha256        string                 `protobuf:"bytes,6,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	TimestampUrl  string                 `protobuf:"bytes,7,opt,name=timestamp_url,json=timestampUrl,proto3" json:"timestamp_url,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileChunk) Reset() {
+	*x = FileChunk{}
+	mi := &file_proto_signer_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileChunk) ProtoMessage() {}
+
+func (x *FileChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_signer_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileChunk.ProtoReflect.Descriptor instead.
+func (*FileChunk) Descriptor() ([]byte, []int) {
+	return file_proto_signer_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *FileChunk) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *FileChunk) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *FileChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *FileChunk) GetCrc32() uint32 {
+	if x != nil {
+		return x.Crc32
+	}
+	return 0
+}
+
+func (x *FileChunk) GetTotalSize() int64 {
+	if x != nil {
+		return x.TotalSize
+	}
+	return 0
+}
+
+func (x *FileChunk) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+func (x *FileChunk) GetTimestampUrl() string {
+	if x != nil {
+		return x.TimestampUrl
+	}
+	return ""
+}
+
+type TransferResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RequestId     string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Size          int64                  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	Sha256        string                 `protobuf:"bytes,3,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransferResult) Reset() {
+	*x = TransferResult{}
+	mi := &file_proto_signer_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransferResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferResult) ProtoMessage() {}
+
+func (x *TransferResult) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_signer_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferResult.ProtoReflect.Descriptor instead.
+func (*TransferResult) Descriptor() ([]byte, []int) {
+	return file_proto_signer_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TransferResult) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *TransferResult) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *TransferResult) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+type ClientEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RequestId     string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	Phase         JobPhase               `protobuf:"varint,2,opt,name=phase,proto3,enum=signer.JobPhase" json:"phase,omitempty"`
+	BytesDone     int64                  `protobuf:"varint,3,opt,name=bytes_done,json=bytesDone,proto3" json:"bytes_done,omitempty"`
+	BytesTotal    int64                  `protobuf:"varint,4,opt,name=bytes_total,json=bytesTotal,proto3" json:"bytes_total,omitempty"`
+	Message       string                 `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ClientEvent) Reset() {
+	*x = ClientEvent{}
+	mi := &file_proto_signer_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ClientEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ClientEvent) ProtoMessage() {}
+
+func (x *ClientEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_signer_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ClientEvent.ProtoReflect.Descriptor instead.
+func (*ClientEvent) Descriptor() ([]byte, []int) {
+	return file_proto_signer_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ClientEvent) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *ClientEvent) GetPhase() JobPhase {
+	if x != nil {
+		return x.Phase
+	}
+	return JobPhase_JOB_PHASE_UNSPECIFIED
+}
+
+func (x *ClientEvent) GetBytesDone() int64 {
+	if x != nil {
+		return x.BytesDone
+	}
+	return 0
+}
+
+func (x *ClientEvent) GetBytesTotal() int64 {
+	if x != nil {
+		return x.BytesTotal
+	}
+	return 0
+}
+
+func (x *ClientEvent) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type ServerCommand struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          CommandType            `protobuf:"varint,1,opt,name=type,proto3,enum=signer.CommandType" json:"type,omitempty"`
+	SignRequest   *SignRequest           `protobuf:"bytes,2,opt,name=sign_request,json=signRequest,proto3" json:"sign_request,omitempty"`
+	RequestId     string                 `protobuf:"bytes,3,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerCommand) Reset() {
+	*x = ServerCommand{}
+	mi := &file_proto_signer_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerCommand) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerCommand) ProtoMessage() {}
+
+func (x *ServerCommand) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_signer_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerCommand.ProtoReflect.Descriptor instead.
+func (*ServerCommand) Descriptor() ([]byte, []int) {
+	return file_proto_signer_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ServerCommand) GetType() CommandType {
+	if x != nil {
+		return x.Type
+	}
+	return CommandType_COMMAND_TYPE_UNSPECIFIED
+}
+
+func (x *ServerCommand) GetSignRequest() *SignRequest {
+	if x != nil {
+		return x.SignRequest
+	}
+	return nil
+}
+
+func (x *ServerCommand) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
 var File_proto_signer_proto protoreflect.FileDescriptor
 
 const file_proto_signer_proto_rawDesc = "" +
 	"\n" +
 	"\x12proto/signer.proto\x12\x06signer\"\a\n" +
-	"\x05Empty\"\x8b\x01\n" +
+	"\x05Empty\"\xbd\x01\n" +
 	"\vSignRequest\x12\x1d\n" +
 	"\n" +
 	"request_id\x18\x01 \x01(\tR\trequestId\x12\x1b\n" +
 	"\tfile_name\x18\x02 \x01(\tR\bfileName\x12!\n" +
 	"\fdownload_url\x18\x03 \x01(\tR\vdownloadUrl\x12\x1d\n" +
 	"\n" +
-	"upload_url\x18\x04 \x01(\tR\tuploadUrl\"_\n" +
+	"upload_url\x18\x04 \x01(\tR\tuploadUrl\x12\x16\n" +
+	"\x06sha256\x18\x05 \x01(\tR\x06sha256\x12\x18\n" +
+	"\aprofile\x18\x06 \x01(\tR\aprofile\"_\n" +
 	"\n" +
 	"SignResult\x12\x1d\n" +
 	"\n" +
 	"request_id\x18\x01 \x01(\tR\trequestId\x12\x18\n" +
 	"\asuccess\x18\x02 \x01(\bR\asuccess\x12\x18\n" +
-	"\amessage\x18\x03 \x01(\tR\amessage2\x82\x01\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\",\n" +
+	"\vFileRequest\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\"\xc8\x01\n" +
+	"\tFileChunk\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12\x14\n" +
+	"\x05crc32\x18\x04 \x01(\rR\x05crc32\x12\x1d\n" +
+	"\n" +
+	"total_size\x18\x05 \x01(\x03R\ttotalSize\x12\x16\n" +
+	"\x06sha256\x18\x06 \x01(\tR\x06sha256\x12#\n" +
+	"\rtimestamp_url\x18\a \x01(\tR\ftimestampUrl\"[\n" +
+	"\x0eTransferResult\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12\x12\n" +
+	"\x04size\x18\x02 \x01(\x03R\x04size\x12\x16\n" +
+	"\x06sha256\x18\x03 \x01(\tR\x06sha256\"\xae\x01\n" +
+	"\vClientEvent\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12&\n" +
+	"\x05phase\x18\x02 \x01(\x0e2\x10.signer.JobPhaseR\x05phase\x12\x1d\n" +
+	"\n" +
+	"bytes_done\x18\x03 \x01(\x03R\tbytesDone\x12\x1f\n" +
+	"\vbytes_total\x18\x04 \x01(\x03R\n" +
+	"bytesTotal\x12\x18\n" +
+	"\amessage\x18\x05 \x01(\tR\amessage\"\x8f\x01\n" +
+	"\rServerCommand\x12'\n" +
+	"\x04type\x18\x01 \x01(\x0e2\x13.signer.CommandTypeR\x04type\x126\n" +
+	"\fsign_request\x18\x02 \x01(\v2\x13.signer.SignRequestR\vsignRequest\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x03 \x01(\tR\trequestId*\xd7\x01\n" +
+	"\bJobPhase\x12\x19\n" +
+	"\x15JOB_PHASE_UNSPECIFIED\x10\x00\x12\x1a\n" +
+	"\x16JOB_PHASE_ACKNOWLEDGED\x10\x01\x12\x19\n" +
+	"\x15JOB_PHASE_DOWNLOADING\x10\x02\x12\x15\n" +
+	"\x11JOB_PHASE_SIGNING\x10\x03\x12\x1a\n" +
+	"\x16JOB_PHASE_TIMESTAMPING\x10\x04\x12\x17\n" +
+	"\x13JOB_PHASE_UPLOADING\x10\x05\x12\x17\n" +
+	"\x13JOB_PHASE_COMPLETED\x10\x06\x12\x14\n" +
+	"\x10JOB_PHASE_FAILED\x10\a*[\n" +
+	"\vCommandType\x12\x1c\n" +
+	"\x18COMMAND_TYPE_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11COMMAND_TYPE_SIGN\x10\x01\x12\x17\n" +
+	"\x13COMMAND_TYPE_CANCEL\x10\x022\xbb\x02\n" +
 	"\rSignerService\x12:\n" +
 	"\x12StreamSignRequests\x12\r.signer.Empty\x1a\x13.signer.SignRequest0\x01\x125\n" +
-	"\x10ReportSignResult\x12\x12.signer.SignResult\x1a\r.signer.EmptyB)Z'github.com/YHVCorp/signer-service/protob\x06proto3"
+	"\x10ReportSignResult\x12\x12.signer.SignResult\x1a\r.signer.Empty\x128\n" +
+	"\fDownloadFile\x12\x13.signer.FileRequest\x1a\x11.signer.FileChunk0\x01\x12?\n" +
+	"\x10UploadSignedFile\x12\x11.signer.FileChunk\x1a\x16.signer.TransferResult(\x01\x12<\n" +
+	"\n" +
+	"JobSession\x12\x13.signer.ClientEvent\x1a\x15.signer.ServerCommand(\x010\x01B)Z'github.com/YHVCorp/signer-service/protob\x06proto3"
 
 var (
 	file_proto_signer_proto_rawDescOnce sync.Once
@@ -220,22 +732,39 @@ func file_proto_signer_proto_rawDescGZIP() []byte {
 	return file_proto_signer_proto_rawDescData
 }
 
-var file_proto_signer_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_proto_signer_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_proto_signer_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_proto_signer_proto_goTypes = []any{
-	(*Empty)(nil),       // 0: signer.Empty
-	(*SignRequest)(nil), // 1: signer.SignRequest
-	(*SignResult)(nil),  // 2: signer.SignResult
+	(JobPhase)(0),          // 0: signer.JobPhase
+	(CommandType)(0),       // 1: signer.CommandType
+	(*Empty)(nil),          // 2: signer.Empty
+	(*SignRequest)(nil),    // 3: signer.SignRequest
+	(*SignResult)(nil),     // 4: signer.SignResult
+	(*FileRequest)(nil),    // 5: signer.FileRequest
+	(*FileChunk)(nil),      // 6: signer.FileChunk
+	(*TransferResult)(nil), // 7: signer.TransferResult
+	(*ClientEvent)(nil),    // 8: signer.ClientEvent
+	(*ServerCommand)(nil),  // 9: signer.ServerCommand
 }
 var file_proto_signer_proto_depIdxs = []int32{
-	0, // 0: signer.SignerService.StreamSignRequests:input_type -> signer.Empty
-	2, // 1: signer.SignerService.ReportSignResult:input_type -> signer.SignResult
-	1, // 2: signer.SignerService.StreamSignRequests:output_type -> signer.SignRequest
-	0, // 3: signer.SignerService.ReportSignResult:output_type -> signer.Empty
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0, // 0: signer.ClientEvent.phase:type_name -> signer.JobPhase
+	1, // 1: signer.ServerCommand.type:type_name -> signer.CommandType
+	3, // 2: signer.ServerCommand.sign_request:type_name -> signer.SignRequest
+	2, // 3: signer.SignerService.StreamSignRequests:input_type -> signer.Empty
+	4, // 4: signer.SignerService.ReportSignResult:input_type -> signer.SignResult
+	5, // 5: signer.SignerService.DownloadFile:input_type -> signer.FileRequest
+	6, // 6: signer.SignerService.UploadSignedFile:input_type -> signer.FileChunk
+	8, // 7: signer.SignerService.JobSession:input_type -> signer.ClientEvent
+	3, // 8: signer.SignerService.StreamSignRequests:output_type -> signer.SignRequest
+	2, // 9: signer.SignerService.ReportSignResult:output_type -> signer.Empty
+	6, // 10: signer.SignerService.DownloadFile:output_type -> signer.FileChunk
+	7, // 11: signer.SignerService.UploadSignedFile:output_type -> signer.TransferResult
+	9, // 12: signer.SignerService.JobSession:output_type -> signer.ServerCommand
+	8, // [8:13] is the sub-list for method output_type
+	3, // [3:8] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_proto_signer_proto_init() }
@@ -248,13 +777,14 @@ func file_proto_signer_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_signer_proto_rawDesc), len(file_proto_signer_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   3,
+			NumEnums:      2,
+			NumMessages:   8,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_proto_signer_proto_goTypes,
 		DependencyIndexes: file_proto_signer_proto_depIdxs,
+		EnumInfos:         file_proto_signer_proto_enumTypes,
 		MessageInfos:      file_proto_signer_proto_msgTypes,
 	}.Build()
 	File_proto_signer_proto = out.File
@@ -21,6 +21,9 @@ const _ = grpc.SupportPackageIsVersion9
 const (
 	SignerService_StreamSignRequests_FullMethodName = "/signer.SignerService/StreamSignRequests"
 	SignerService_ReportSignResult_FullMethodName   = "/signer.SignerService/ReportSignResult"
+	SignerService_DownloadFile_FullMethodName       = "/signer.SignerService/DownloadFile"
+	SignerService_UploadSignedFile_FullMethodName   = "/signer.SignerService/UploadSignedFile"
+	SignerService_JobSession_FullMethodName         = "/signer.SignerService/JobSession"
 )
 
 // SignerServiceClient is the client API for SignerService service.
@@ -29,6 +32,9 @@ const (
 type SignerServiceClient interface {
 	StreamSignRequests(ctx context.Context, in *Empty, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SignRequest], error)
 	ReportSignResult(ctx context.Context, in *SignResult, opts ...grpc.CallOption) (*Empty, error)
+	DownloadFile(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FileChunk], error)
+	UploadSignedFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[FileChunk, TransferResult], error)
+	JobSession(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ClientEvent, ServerCommand], error)
 }
 
 type signerServiceClient struct {
@@ -68,12 +74,60 @@ func (c *signerServiceClient) ReportSignResult(ctx context.Context, in *SignResu
 	return out, nil
 }
 
+func (c *signerServiceClient) DownloadFile(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FileChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SignerService_ServiceDesc.Streams[1], SignerService_DownloadFile_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[FileRequest, FileChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignerService_DownloadFileClient = grpc.ServerStreamingClient[FileChunk]
+
+func (c *signerServiceClient) UploadSignedFile(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[FileChunk, TransferResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SignerService_ServiceDesc.Streams[2], SignerService_UploadSignedFile_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[FileChunk, TransferResult]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignerService_UploadSignedFileClient = grpc.ClientStreamingClient[FileChunk, TransferResult]
+
+func (c *signerServiceClient) JobSession(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ClientEvent, ServerCommand], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &SignerService_ServiceDesc.Streams[3], SignerService_JobSession_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ClientEvent, ServerCommand]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignerService_JobSessionClient = grpc.BidiStreamingClient[ClientEvent, ServerCommand]
+
 // SignerServiceServer is the server API for SignerService service.
 // All implementations must embed UnimplementedSignerServiceServer
 // for forward compatibility.
 type SignerServiceServer interface {
 	StreamSignRequests(*Empty, grpc.ServerStreamingServer[SignRequest]) error
 	ReportSignResult(context.Context, *SignResult) (*Empty, error)
+	DownloadFile(*FileRequest, grpc.ServerStreamingServer[FileChunk]) error
+	UploadSignedFile(grpc.ClientStreamingServer[FileChunk, TransferResult]) error
+	JobSession(grpc.BidiStreamingServer[ClientEvent, ServerCommand]) error
 	mustEmbedUnimplementedSignerServiceServer()
 }
 
@@ -90,6 +144,15 @@ func (UnimplementedSignerServiceServer) StreamSignRequests(*Empty, grpc.ServerSt
 func (UnimplementedSignerServiceServer) ReportSignResult(context.Context, *SignResult) (*Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ReportSignResult not implemented")
 }
+func (UnimplementedSignerServiceServer) DownloadFile(*FileRequest, grpc.ServerStreamingServer[FileChunk]) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadFile not implemented")
+}
+func (UnimplementedSignerServiceServer) UploadSignedFile(grpc.ClientStreamingServer[FileChunk, TransferResult]) error {
+	return status.Errorf(codes.Unimplemented, "method UploadSignedFile not implemented")
+}
+func (UnimplementedSignerServiceServer) JobSession(grpc.BidiStreamingServer[ClientEvent, ServerCommand]) error {
+	return status.Errorf(codes.Unimplemented, "method JobSession not implemented")
+}
 func (UnimplementedSignerServiceServer) mustEmbedUnimplementedSignerServiceServer() {}
 func (UnimplementedSignerServiceServer) testEmbeddedByValue()                       {}
 
@@ -140,6 +203,31 @@ func _SignerService_ReportSignResult_Handler(srv interface{}, ctx context.Contex
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SignerService_DownloadFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FileRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SignerServiceServer).DownloadFile(m, &grpc.GenericServerStream[FileRequest, FileChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignerService_DownloadFileServer = grpc.ServerStreamingServer[FileChunk]
+
+func _SignerService_UploadSignedFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SignerServiceServer).UploadSignedFile(&grpc.GenericServerStream[FileChunk, TransferResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignerService_UploadSignedFileServer = grpc.ClientStreamingServer[FileChunk, TransferResult]
+
+func _SignerService_JobSession_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SignerServiceServer).JobSession(&grpc.GenericServerStream[ClientEvent, ServerCommand]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type SignerService_JobSessionServer = grpc.BidiStreamingServer[ClientEvent, ServerCommand]
+
 // SignerService_ServiceDesc is the grpc.ServiceDesc for SignerService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -158,6 +246,22 @@ var SignerService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _SignerService_StreamSignRequests_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "DownloadFile",
+			Handler:       _SignerService_DownloadFile_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "UploadSignedFile",
+			Handler:       _SignerService_UploadSignedFile_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "JobSession",
+			Handler:       _SignerService_JobSession_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "proto/signer.proto",
 }
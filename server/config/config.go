@@ -1,7 +1,6 @@
 package config
 
 import (
-	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"os"
@@ -18,55 +17,71 @@ const (
 )
 
 type Config struct {
-	Token string `yaml:"token"`
+	// Token is the single token of configs written before tokens had names,
+	// accepted as DefaultTokenName until a token command moves it to Tokens.
+	Token string `yaml:"token,omitempty"`
+	// Tokens are the authentication tokens accepted from clients and
+	// pipelines.
+	Tokens []Token `yaml:"tokens,omitempty"`
+	// SignerThumbprint is the SHA-1 thumbprint of the certificate signed
+	// files must carry, unless the upload asks for another one.
+	SignerThumbprint string `yaml:"signer_thumbprint,omitempty"`
+	// ProfileThumbprints maps signing profiles to the thumbprint of their
+	// certificate.
+	ProfileThumbprints map[string]string `yaml:"profile_thumbprints,omitempty"`
+	// RequireThumbprint rejects uploads for which no expected thumbprint
+	// applies, and files whose signature cannot be verified, so a signed
+	// file is never accepted from an arbitrary certificate.
+	RequireThumbprint bool `yaml:"require_thumbprint,omitempty"`
+
+	Listen   ListenConfig   `yaml:"listen,omitempty"`
+	Storage  StorageConfig  `yaml:"storage,omitempty"`
+	Limits   LimitsConfig   `yaml:"limits,omitempty"`
+	Queue    QueueConfig    `yaml:"queue,omitempty"`
+	TLS      TLSConfig      `yaml:"tls,omitempty"`
+	Webhooks WebhooksConfig `yaml:"webhooks,omitempty"`
+}
+
+// ExpectedThumbprint returns the thumbprint files signed with profile must
+// carry, empty when any signer is accepted. SignerThumbprint only applies to
+// jobs without a profile.
+func (c *Config) ExpectedThumbprint(profile string) string {
+	if profile == "" {
+		return c.SignerThumbprint
+	}
+	return c.ProfileThumbprints[profile]
 }
 
 func GetConfigPath() string {
 	return filepath.Join(utils.GetMyPath(), ConfigFileName)
 }
 
+// GenerateConfig writes the config with a single token, named
+// DefaultTokenName, and returns the token.
 func GenerateConfig() (string, error) {
-	// Generate random token
-	tokenBytes := make([]byte, 32)
-	if _, err := rand.Read(tokenBytes); err != nil {
-		return "", fmt.Errorf("error generating token: %v", err)
-	}
-	token := base64.StdEncoding.EncodeToString(tokenBytes)
-
-	// Generate salt
-	salt := make([]byte, SaltSize)
-	if _, err := rand.Read(salt); err != nil {
-		return "", fmt.Errorf("error generating salt: %v", err)
-	}
-
-	// Encrypt token with salt as key
-	encrypted, err := aesCrypt.AESEncrypt(token, salt)
+	token, value, err := newToken(DefaultTokenName)
 	if err != nil {
-		return "", fmt.Errorf("error encrypting token: %v", err)
-	}
-
-	// Decode encrypted string to bytes and concatenate with salt
-	encryptedBytes, err := base64.StdEncoding.DecodeString(encrypted)
-	if err != nil {
-		return "", fmt.Errorf("error decoding encrypted data: %v", err)
+		return "", err
 	}
-	final := append(encryptedBytes, salt...)
-	encryptedToken := base64.StdEncoding.EncodeToString(final)
 
-	// Save to YAML config file
-	config := Config{Token: encryptedToken}
-	configData, err := yaml.Marshal(config)
-	if err != nil {
-		return "", fmt.Errorf("error marshaling config: %v", err)
+	// Keep the other settings of an existing config file, which may have
+	// been provisioned before install
+	config := Config{}
+	if _, err := os.Stat(GetConfigPath()); err == nil {
+		existing, err := GetConfig()
+		if err != nil {
+			return "", err
+		}
+		config = *existing
 	}
+	config.Token = ""
+	config.Tokens = []Token{token}
 
-	configPath := GetConfigPath()
-	err = os.WriteFile(configPath, configData, 0600)
-	if err != nil {
-		return "", fmt.Errorf("error writing config file: %v", err)
+	if err := writeConfig(&config); err != nil {
+		return "", err
 	}
 
-	return token, nil
+	return value, nil
 }
 
 func GetConfig() (*Config, error) {
@@ -80,13 +95,20 @@ func GetConfig() (*Config, error) {
 	return &config, nil
 }
 
-func GetDecryptedToken() (string, error) {
-	config, err := GetConfig()
+// readConfigStrict reads the config file, rejecting unknown settings so a
+// misspelled key is reported instead of ignored.
+func readConfigStrict() (*Config, error) {
+	data, err := os.ReadFile(GetConfigPath())
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("error reading config: %v", err)
+	}
+
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("error reading config %s: %v", GetConfigPath(), err)
 	}
 
-	return DecryptToken(config.Token)
+	return &config, nil
 }
 
 func DecryptToken(encryptedToken string) (string, error) {
@@ -115,14 +137,3 @@ func DecryptToken(encryptedToken string) (string, error) {
 
 	return decrypted, nil
 }
-
-func GenerateNewToken() (string, error) {
-	// Remove existing config file
-	configPath := GetConfigPath()
-	if _, err := os.Stat(configPath); err == nil {
-		os.Remove(configPath)
-	}
-
-	// Generate new config
-	return GenerateConfig()
-}
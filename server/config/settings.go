@@ -0,0 +1,308 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+const (
+	DefaultGRPCAddress = ":50052"
+	DefaultHTTPAddress = ":8081"
+)
+
+// ListenConfig holds the addresses of the listeners, host:port or a bare
+// port to listen on every interface.
+type ListenConfig struct {
+	GRPC string `yaml:"grpc,omitempty"`
+	HTTP string `yaml:"http,omitempty"`
+}
+
+// StorageConfig holds the locations of the server data. Relative paths are
+// resolved against DataDir, which defaults to the directory of the
+// executable.
+type StorageConfig struct {
+	DataDir   string `yaml:"data_dir,omitempty"`
+	Uploads   string `yaml:"uploads,omitempty"`
+	Downloads string `yaml:"downloads,omitempty"`
+	Shared    string `yaml:"shared,omitempty"`
+	JobsDB    string `yaml:"jobs_db,omitempty"`
+}
+
+// LimitsConfig bounds what the server accepts.
+type LimitsConfig struct {
+	// MaxUploadMB is the largest file accepted by /api/v1/upload, in MiB.
+	// 0 accepts any size.
+	MaxUploadMB int64 `yaml:"max_upload_mb,omitempty"`
+	// Retention is how long finished, failed and cancelled jobs are kept
+	// before their files are removed. 0 keeps them until they are finished
+	// through the API.
+	Retention time.Duration `yaml:"retention,omitempty"`
+}
+
+// QueueConfig tunes the job queue. Zero values keep the server defaults.
+type QueueConfig struct {
+	// LeaseTimeout is how long a client may hold a job before it is
+	// requeued
+	LeaseTimeout time.Duration `yaml:"lease_timeout,omitempty"`
+	// MaxAttempts is how many times a job is dispatched before it fails
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// MaxPending is the number of jobs waiting for a client above which
+	// uploads are rejected. 0 does not limit the queue.
+	MaxPending int `yaml:"max_pending,omitempty"`
+}
+
+// WebhooksConfig restricts where webhooks are sent.
+type WebhooksConfig struct {
+	// AllowedNetworks are the networks, in CIDR notation or as single
+	// addresses, callback URLs may point into although they are loopback,
+	// private or link-local.
+	AllowedNetworks []string `yaml:"allowed_networks,omitempty"`
+}
+
+// Networks parses AllowedNetworks.
+func (w WebhooksConfig) Networks() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range w.AllowedNetworks {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// TLSConfig holds the PEM files of the gRPC and HTTP listeners. Both
+// listeners are plain text when Cert and Key are unset.
+type TLSConfig struct {
+	Cert string `yaml:"cert,omitempty"`
+	Key  string `yaml:"key,omitempty"`
+	// ClientCA is a PEM bundle of the CAs client certificates must be
+	// issued by. Client certificates are not requested when unset.
+	ClientCA string `yaml:"client_ca,omitempty"`
+}
+
+// envOverrides maps the environment variables that override config.yaml to
+// the setting they replace, for containerized deployments.
+var envOverrides = []struct {
+	name string
+	set  func(c *Config, value string) error
+}{
+	{"SIGNER_GRPC_ADDRESS", func(c *Config, v string) error { c.Listen.GRPC = v; return nil }},
+	{"SIGNER_HTTP_ADDRESS", func(c *Config, v string) error { c.Listen.HTTP = v; return nil }},
+	{"SIGNER_DATA_DIR", func(c *Config, v string) error { c.Storage.DataDir = v; return nil }},
+	{"SIGNER_UPLOADS_DIR", func(c *Config, v string) error { c.Storage.Uploads = v; return nil }},
+	{"SIGNER_DOWNLOADS_DIR", func(c *Config, v string) error { c.Storage.Downloads = v; return nil }},
+	{"SIGNER_SHARED_DIR", func(c *Config, v string) error { c.Storage.Shared = v; return nil }},
+	{"SIGNER_JOBS_DB", func(c *Config, v string) error { c.Storage.JobsDB = v; return nil }},
+	{"SIGNER_MAX_UPLOAD_MB", func(c *Config, v string) error { return parseInt64(v, &c.Limits.MaxUploadMB) }},
+	{"SIGNER_RETENTION", func(c *Config, v string) error { return parseDuration(v, &c.Limits.Retention) }},
+	{"SIGNER_LEASE_TIMEOUT", func(c *Config, v string) error { return parseDuration(v, &c.Queue.LeaseTimeout) }},
+	{"SIGNER_MAX_ATTEMPTS", func(c *Config, v string) error { return parseInt(v, &c.Queue.MaxAttempts) }},
+	{"SIGNER_MAX_PENDING", func(c *Config, v string) error { return parseInt(v, &c.Queue.MaxPending) }},
+	{"SIGNER_TLS_CERT", func(c *Config, v string) error { c.TLS.Cert = v; return nil }},
+	{"SIGNER_TLS_KEY", func(c *Config, v string) error { c.TLS.Key = v; return nil }},
+	{"SIGNER_TLS_CLIENT_CA", func(c *Config, v string) error { c.TLS.ClientCA = v; return nil }},
+	{"SIGNER_WEBHOOK_ALLOWED_NETWORKS", func(c *Config, v string) error { c.Webhooks.AllowedNetworks = strings.Split(v, ","); return nil }},
+}
+
+// LoadConfig reads config.yaml, applies the environment overrides and the
+// defaults, and validates the result. It is used once at startup; the token,
+// webhooks and thumbprints are read again from the file on every use.
+func LoadConfig() (*Config, error) {
+	cnf, err := readConfigStrict()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, override := range envOverrides {
+		value, ok := os.LookupEnv(override.name)
+		if !ok {
+			continue
+		}
+		if err := override.set(cnf, value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", override.name, err)
+		}
+	}
+
+	cnf.applyDefaults()
+
+	if err := cnf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration in %s: %v", GetConfigPath(), err)
+	}
+
+	return cnf, nil
+}
+
+func (c *Config) applyDefaults() {
+	if c.Listen.GRPC == "" {
+		c.Listen.GRPC = DefaultGRPCAddress
+	}
+	if c.Listen.HTTP == "" {
+		c.Listen.HTTP = DefaultHTTPAddress
+	}
+	c.Listen.GRPC = normalizeAddress(c.Listen.GRPC)
+	c.Listen.HTTP = normalizeAddress(c.Listen.HTTP)
+
+	if c.Storage.DataDir == "" {
+		c.Storage.DataDir = utils.GetMyPath()
+	}
+	c.Storage.Uploads = resolvePath(c.Storage.DataDir, c.Storage.Uploads, "uploads")
+	c.Storage.Downloads = resolvePath(c.Storage.DataDir, c.Storage.Downloads, "downloads")
+	c.Storage.Shared = resolvePath(c.Storage.DataDir, c.Storage.Shared, "shared")
+	c.Storage.JobsDB = resolvePath(c.Storage.DataDir, c.Storage.JobsDB, "jobs.db")
+}
+
+// Validate checks the listen addresses, storage paths, limits, TLS files and
+// webhook networks.
+func (c *Config) Validate() error {
+	for _, listen := range []struct{ name, address string }{
+		{"listen.grpc", c.Listen.GRPC},
+		{"listen.http", c.Listen.HTTP},
+	} {
+		_, port, err := net.SplitHostPort(listen.address)
+		if err != nil {
+			return fmt.Errorf("%s: invalid address %q: %v", listen.name, listen.address, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("%s: invalid port %q", listen.name, port)
+		}
+	}
+	if c.Listen.GRPC == c.Listen.HTTP {
+		return fmt.Errorf("listen.grpc and listen.http both use %s", c.Listen.GRPC)
+	}
+
+	if !filepath.IsAbs(c.Storage.DataDir) {
+		return fmt.Errorf("storage.data_dir: path must be absolute: %s", c.Storage.DataDir)
+	}
+	dirs := map[string]string{}
+	for _, dir := range []struct{ name, path string }{
+		{"storage.uploads", filepath.Clean(c.Storage.Uploads)},
+		{"storage.downloads", filepath.Clean(c.Storage.Downloads)},
+		{"storage.shared", filepath.Clean(c.Storage.Shared)},
+	} {
+		if other, ok := dirs[dir.path]; ok {
+			return fmt.Errorf("%s and %s both use %s", other, dir.name, dir.path)
+		}
+		// Files with no matching job are removed from the uploads and
+		// downloads directories on startup
+		if dir.path == filepath.Clean(c.Storage.DataDir) {
+			return fmt.Errorf("%s: must not be the data directory: %s", dir.name, dir.path)
+		}
+		for _, file := range []struct{ name, path string }{
+			{"storage.jobs_db", c.Storage.JobsDB},
+			{"the config file", GetConfigPath()},
+		} {
+			if utils.IsSubPath(filepath.Clean(file.path), dir.path) {
+				return fmt.Errorf("%s: must not contain %s: %s", dir.name, file.name, dir.path)
+			}
+		}
+		dirs[dir.path] = dir.name
+		if info, err := os.Stat(dir.path); err == nil && !info.IsDir() {
+			return fmt.Errorf("%s: not a directory: %s", dir.name, dir.path)
+		}
+	}
+
+	if c.Limits.MaxUploadMB < 0 {
+		return fmt.Errorf("limits.max_upload_mb: must not be negative")
+	}
+	if c.Limits.Retention < 0 {
+		return fmt.Errorf("limits.retention: must not be negative")
+	}
+	if c.Queue.LeaseTimeout < 0 {
+		return fmt.Errorf("queue.lease_timeout: must not be negative")
+	}
+	if c.Queue.MaxAttempts < 0 {
+		return fmt.Errorf("queue.max_attempts: must not be negative")
+	}
+	if c.Queue.MaxPending < 0 {
+		return fmt.Errorf("queue.max_pending: must not be negative")
+	}
+
+	if (c.TLS.Cert == "") != (c.TLS.Key == "") {
+		return fmt.Errorf("tls.cert and tls.key must be set together")
+	}
+	if c.TLS.ClientCA != "" && c.TLS.Cert == "" {
+		return fmt.Errorf("tls.client_ca requires tls.cert and tls.key")
+	}
+	for _, file := range []struct{ name, path string }{
+		{"tls.cert", c.TLS.Cert},
+		{"tls.key", c.TLS.Key},
+		{"tls.client_ca", c.TLS.ClientCA},
+	} {
+		if file.path != "" && !utils.FileExists(file.path) {
+			return fmt.Errorf("%s: file does not exist: %s", file.name, file.path)
+		}
+	}
+
+	if _, err := c.Webhooks.Networks(); err != nil {
+		return fmt.Errorf("webhooks.allowed_networks: %v", err)
+	}
+
+	return nil
+}
+
+// normalizeAddress turns a bare port into a listen address.
+func normalizeAddress(address string) string {
+	if _, err := strconv.Atoi(address); err == nil {
+		return ":" + address
+	}
+	return address
+}
+
+func resolvePath(base, path, fallback string) string {
+	if path == "" {
+		path = fallback
+	}
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(base, path)
+}
+
+func parseInt(value string, out *int) error {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+	*out = n
+	return nil
+}
+
+func parseInt64(value string, out *int64) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return err
+	}
+	*out = n
+	return nil
+}
+
+func parseDuration(value string, out *time.Duration) error {
+	d, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return err
+	}
+	*out = d
+	return nil
+}
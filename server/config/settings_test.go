@@ -0,0 +1,77 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+func TestValidateStorage(t *testing.T) {
+	dataDir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		storage StorageConfig
+		wantErr string
+	}{
+		{
+			name:    "defaults",
+			storage: StorageConfig{DataDir: dataDir},
+		},
+		{
+			name:    "uploads is the data directory",
+			storage: StorageConfig{DataDir: dataDir, Uploads: "."},
+			wantErr: "storage.uploads: must not be the data directory",
+		},
+		{
+			name:    "downloads is the data directory with a trailing separator",
+			storage: StorageConfig{DataDir: dataDir, Downloads: dataDir + string(filepath.Separator)},
+			wantErr: "storage.downloads: must not be the data directory",
+		},
+		{
+			name:    "shared is the data directory through a parent reference",
+			storage: StorageConfig{DataDir: dataDir, Shared: "shared/.."},
+			wantErr: "storage.shared: must not be the data directory",
+		},
+		{
+			name:    "uploads contains the jobs database",
+			storage: StorageConfig{DataDir: dataDir, JobsDB: "uploads/jobs.db"},
+			wantErr: "storage.uploads: must not contain storage.jobs_db",
+		},
+		{
+			name:    "downloads contains the data directory",
+			storage: StorageConfig{DataDir: dataDir, Downloads: filepath.Dir(dataDir)},
+			wantErr: "storage.downloads: must not contain storage.jobs_db",
+		},
+		{
+			name:    "shared contains the config file",
+			storage: StorageConfig{DataDir: dataDir, Shared: utils.GetMyPath()},
+			wantErr: "storage.shared: must not contain the config file",
+		},
+		{
+			name:    "uploads and downloads are the same",
+			storage: StorageConfig{DataDir: dataDir, Uploads: "files", Downloads: "files/"},
+			wantErr: "storage.uploads and storage.downloads both use",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cnf := &Config{Storage: tt.storage}
+			cnf.applyDefaults()
+
+			err := cnf.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
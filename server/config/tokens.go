@@ -0,0 +1,393 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	aesCrypt "github.com/AtlasInsideCorp/AtlasInsideAES"
+	"github.com/YHVCorp/signer-service/server/utils"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultTokenName is the name of the token created on install, and of
+	// the token of configs written before tokens had names.
+	DefaultTokenName = "default"
+	// DefaultGracePeriod is how long replaced tokens stay valid after a
+	// rotation.
+	DefaultGracePeriod = 24 * time.Hour
+)
+
+// Token is a named authentication token. Value is encrypted like the legacy
+// token field.
+type Token struct {
+	Name      string    `yaml:"name"`
+	Value     string    `yaml:"value"`
+	CreatedAt time.Time `yaml:"created_at"`
+	// ExpiresAt is when the token stops being accepted, never when zero
+	ExpiresAt time.Time `yaml:"expires_at,omitempty"`
+	// Webhooks are called for every job uploaded with the token, in addition
+	// to the callback URL given on upload
+	Webhooks []string `yaml:"webhooks,omitempty"`
+	// WebhookSecret is the encrypted HMAC key of the webhooks of the jobs
+	// uploaded with the token, kept apart from Value so receivers cannot
+	// submit jobs
+	WebhookSecret string `yaml:"webhook_secret,omitempty"`
+}
+
+// Expired reports whether the token is no longer accepted at now.
+func (t Token) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// AllTokens returns the tokens of the config, including the legacy token
+// field as DefaultTokenName.
+func (c *Config) AllTokens() []Token {
+	tokens := append([]Token(nil), c.Tokens...)
+	if c.Token != "" {
+		tokens = append([]Token{{Name: DefaultTokenName, Value: c.Token}}, tokens...)
+	}
+	return tokens
+}
+
+// ValidateToken returns the name of the unexpired token matching token.
+func ValidateToken(token string) (string, error) {
+	cnf, err := GetConfig()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	for _, t := range cnf.AllTokens() {
+		if t.Expired(now) {
+			continue
+		}
+		expected, err := DecryptToken(t.Value)
+		if err != nil {
+			// A damaged entry must not lock out the clients of the others
+			utils.Logger.ErrorF("Skipping token %s: %v", t.Name, err)
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			return t.Name, nil
+		}
+	}
+
+	return "", fmt.Errorf("invalid token")
+}
+
+// TokenWebhooks returns the webhooks of the named token and their decrypted
+// secret, empty when none is configured. Expired tokens keep their webhooks
+// for the jobs uploaded before they expired.
+func TokenWebhooks(name string) ([]string, string, error) {
+	cnf, err := GetConfig()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, t := range cnf.AllTokens() {
+		if t.Name != name {
+			continue
+		}
+		if t.WebhookSecret == "" {
+			return t.Webhooks, "", nil
+		}
+		secret, err := DecryptToken(t.WebhookSecret)
+		if err != nil {
+			return nil, "", fmt.Errorf("webhook secret of token %s: %v", name, err)
+		}
+		return t.Webhooks, secret, nil
+	}
+
+	return nil, "", nil
+}
+
+// AddToken creates a token valid for ttl, or until it is expired or revoked
+// when ttl is 0, and returns its value.
+func AddToken(name string, ttl time.Duration) (string, error) {
+	if !validTokenName(name) {
+		return "", fmt.Errorf("invalid token name: %s", name)
+	}
+	if ttl < 0 {
+		return "", fmt.Errorf("invalid token lifetime: %v", ttl)
+	}
+
+	cnf, err := GetConfig()
+	if err != nil {
+		return "", err
+	}
+	migrateLegacyToken(cnf)
+
+	for _, t := range cnf.Tokens {
+		if t.Name == name {
+			return "", fmt.Errorf("token %s already exists", name)
+		}
+	}
+
+	token, value, err := newToken(name)
+	if err != nil {
+		return "", err
+	}
+	if ttl > 0 {
+		token.ExpiresAt = token.CreatedAt.Add(ttl)
+	}
+	cnf.Tokens = append(cnf.Tokens, token)
+
+	return value, writeConfig(cnf)
+}
+
+// RotateTokens adds a new token and expires every other token after grace,
+// so clients and pipelines can be moved to the new token without downtime.
+// Tokens already expiring sooner keep their expiry.
+func RotateTokens(grace time.Duration) (string, string, error) {
+	if grace < 0 {
+		return "", "", fmt.Errorf("invalid grace period: %v", grace)
+	}
+
+	cnf, err := GetConfig()
+	if err != nil {
+		return "", "", err
+	}
+	migrateLegacyToken(cnf)
+
+	now := time.Now().UTC()
+	expiry := now.Add(grace)
+	for i, t := range cnf.Tokens {
+		if t.ExpiresAt.IsZero() || t.ExpiresAt.After(expiry) {
+			cnf.Tokens[i].ExpiresAt = expiry
+		}
+	}
+
+	name := uniqueTokenName(cnf, "token-"+now.Format("20060102-150405"))
+	token, value, err := newToken(name)
+	if err != nil {
+		return "", "", err
+	}
+	cnf.Tokens = append(cnf.Tokens, token)
+
+	return name, value, writeConfig(cnf)
+}
+
+// ExpireToken makes the named token stop being accepted after grace.
+func ExpireToken(name string, grace time.Duration) error {
+	if grace < 0 {
+		return fmt.Errorf("invalid grace period: %v", grace)
+	}
+
+	return updateToken(name, func(cnf *Config, i int) {
+		cnf.Tokens[i].ExpiresAt = time.Now().UTC().Add(grace)
+	})
+}
+
+// RevokeToken removes the named token, which stops being accepted at once.
+func RevokeToken(name string) error {
+	return updateToken(name, func(cnf *Config, i int) {
+		cnf.Tokens = append(cnf.Tokens[:i], cnf.Tokens[i+1:]...)
+	})
+}
+
+// AddWebhook adds a webhook called for the jobs uploaded with the named
+// token. Webhooks are never sent unsigned, so a secret is generated for a
+// token that has none yet and returned; the returned secret is empty when the
+// token already had one.
+func AddWebhook(name, webhook string) (string, error) {
+	if u, err := url.Parse(webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid webhook URL: %s", webhook)
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := encryptToken(secret)
+	if err != nil {
+		return "", err
+	}
+
+	generated := false
+	err = updateToken(name, func(cnf *Config, i int) {
+		if cnf.Tokens[i].WebhookSecret == "" {
+			cnf.Tokens[i].WebhookSecret = encrypted
+			generated = true
+		}
+		for _, existing := range cnf.Tokens[i].Webhooks {
+			if existing == webhook {
+				return
+			}
+		}
+		cnf.Tokens[i].Webhooks = append(cnf.Tokens[i].Webhooks, webhook)
+	})
+	if err != nil || !generated {
+		return "", err
+	}
+	return secret, nil
+}
+
+func RemoveWebhook(name, webhook string) error {
+	removed := false
+	err := updateToken(name, func(cnf *Config, i int) {
+		webhooks := cnf.Tokens[i].Webhooks[:0]
+		for _, existing := range cnf.Tokens[i].Webhooks {
+			if existing == webhook {
+				removed = true
+				continue
+			}
+			webhooks = append(webhooks, existing)
+		}
+		cnf.Tokens[i].Webhooks = webhooks
+	})
+	if err == nil && !removed {
+		return fmt.Errorf("token %s has no webhook %s", name, webhook)
+	}
+	return err
+}
+
+// RotateWebhookSecret generates a new secret for the webhooks of the named
+// token and returns it. The previous secret stops being used at once.
+func RotateWebhookSecret(name string) (string, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := encryptToken(secret)
+	if err != nil {
+		return "", err
+	}
+
+	err = updateToken(name, func(cnf *Config, i int) {
+		cnf.Tokens[i].WebhookSecret = encrypted
+	})
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func updateToken(name string, update func(cnf *Config, i int)) error {
+	cnf, err := GetConfig()
+	if err != nil {
+		return err
+	}
+	migrateLegacyToken(cnf)
+
+	for i, t := range cnf.Tokens {
+		if t.Name == name {
+			update(cnf, i)
+			return writeConfig(cnf)
+		}
+	}
+
+	return fmt.Errorf("token %s does not exist", name)
+}
+
+// uniqueTokenName returns name, with a numeric suffix when a token already
+// has that name, as rotations within the same second would otherwise repeat
+// it.
+func uniqueTokenName(cnf *Config, name string) string {
+	taken := map[string]bool{}
+	for _, t := range cnf.AllTokens() {
+		taken[t.Name] = true
+	}
+
+	unique := name
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+	return unique
+}
+
+// migrateLegacyToken moves the token field of configs written before tokens
+// had names into the token list.
+func migrateLegacyToken(cnf *Config) {
+	if cnf.Token == "" {
+		return
+	}
+
+	var createdAt time.Time
+	if info, err := os.Stat(GetConfigPath()); err == nil {
+		createdAt = info.ModTime()
+	}
+	cnf.Tokens = append([]Token{{Name: DefaultTokenName, Value: cnf.Token, CreatedAt: createdAt}}, cnf.Tokens...)
+	cnf.Token = ""
+}
+
+// newToken generates a random token and returns it with its encrypted form.
+func newToken(name string) (Token, string, error) {
+	value, err := generateSecret()
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	encrypted, err := encryptToken(value)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	return Token{Name: name, Value: encrypted, CreatedAt: time.Now().UTC()}, value, nil
+}
+
+// generateSecret returns 32 random bytes in base64, used for tokens and
+// webhook secrets.
+func generateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(secret), nil
+}
+
+func encryptToken(token string) (string, error) {
+	// Generate salt
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("error generating salt: %v", err)
+	}
+
+	// Encrypt token with salt as key
+	encrypted, err := aesCrypt.AESEncrypt(token, salt)
+	if err != nil {
+		return "", fmt.Errorf("error encrypting token: %v", err)
+	}
+
+	// Decode encrypted string to bytes and concatenate with salt
+	encryptedBytes, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("error decoding encrypted data: %v", err)
+	}
+	final := append(encryptedBytes, salt...)
+	return base64.StdEncoding.EncodeToString(final), nil
+}
+
+func writeConfig(cnf *Config) error {
+	configData, err := yaml.Marshal(cnf)
+	if err != nil {
+		return fmt.Errorf("error marshaling config: %v", err)
+	}
+
+	if err := os.WriteFile(GetConfigPath(), configData, 0600); err != nil {
+		return fmt.Errorf("error writing config file: %v", err)
+	}
+
+	return nil
+}
+
+// validTokenName reports whether name can name a token: letters, digits,
+// dots, dashes and underscores.
+func validTokenName(name string) bool {
+	if len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+// writeTestConfig replaces the config file for the duration of the test.
+func writeTestConfig(t *testing.T, cnf *Config) {
+	t.Helper()
+	if err := writeConfig(cnf); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(GetConfigPath()) })
+}
+
+func TestValidateTokenSkipsCorruptEntries(t *testing.T) {
+	utils.InitLogger("stdout")
+
+	valid, value, err := newToken("ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, expiredValue, err := newToken("old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+
+	writeTestConfig(t, &Config{Tokens: []Token{
+		{Name: "broken", Value: "not encrypted"},
+		expired,
+		valid,
+	}})
+
+	name, err := ValidateToken(value)
+	if err != nil {
+		t.Fatalf("ValidateToken() = %v, want token ci", err)
+	}
+	if name != "ci" {
+		t.Errorf("ValidateToken() = %s, want ci", name)
+	}
+
+	if _, err := ValidateToken(expiredValue); err == nil {
+		t.Error("ValidateToken() accepted an expired token")
+	}
+	if _, err := ValidateToken("not encrypted"); err == nil {
+		t.Error("ValidateToken() accepted an unknown token")
+	}
+}
+
+func TestRotateTokensUniqueNames(t *testing.T) {
+	if _, err := GenerateConfig(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(GetConfigPath()) })
+
+	names := map[string]bool{DefaultTokenName: true}
+	for i := 0; i < 3; i++ {
+		name, value, err := RotateTokens(time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names[name] {
+			t.Fatalf("RotateTokens() reused the name %s", name)
+		}
+		names[name] = true
+
+		if got, err := ValidateToken(value); err != nil || got != name {
+			t.Fatalf("ValidateToken() = %s, %v, want %s", got, err, name)
+		}
+	}
+
+	cnf, err := GetConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cnf.Tokens) != len(names) {
+		t.Fatalf("config has %d tokens, want %d", len(cnf.Tokens), len(names))
+	}
+	for _, token := range cnf.Tokens {
+		if token.Name == DefaultTokenName && token.ExpiresAt.IsZero() {
+			t.Error("rotation did not expire the default token")
+		}
+	}
+}
@@ -8,6 +8,7 @@ require (
 	github.com/gin-gonic/gin v1.10.1
 	github.com/kardianos/service v1.2.2
 	github.com/threatwinds/logger v1.2.2
+	go.etcd.io/bbolt v1.4.0
 	google.golang.org/grpc v1.73.0
 	gopkg.in/yaml.v2 v2.4.0
 )
@@ -15,7 +16,8 @@ require (
 require google.golang.org/protobuf v1.36.6 // indirect
 
 require (
-	github.com/YHVCorp/signer-service/proto v0.0.0-20250715154513-3d7c6a992c9b
+	github.com/YHVCorp/signer-service/authenticode v0.0.0
+	github.com/YHVCorp/signer-service/proto v0.0.0
 	github.com/bytedance/sonic v1.13.3 // indirect
 	github.com/bytedance/sonic/loader v0.2.4 // indirect
 	github.com/cloudwego/base64x v0.1.5 // indirect
@@ -44,3 +46,7 @@ require (
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/YHVCorp/signer-service/proto => ../proto
+
+replace github.com/YHVCorp/signer-service/authenticode => ../authenticode
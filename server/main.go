@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/YHVCorp/signer-service/server/config"
 	"github.com/YHVCorp/signer-service/server/serv"
@@ -52,13 +53,80 @@ func main() {
 			fmt.Printf("SignerServiceServer service installed correctly. You can use the token: %s for authenticate clients\n", token)
 
 		case "generate-new-token":
+			grace := parseDurationArg(2, config.DefaultGracePeriod)
 			fmt.Println("Generating new authentication token ...")
-			token, err := config.GenerateNewToken()
+			name, token, err := config.RotateTokens(grace)
 			if err != nil {
 				fmt.Printf("Error generating new token: %v\n", err)
 				os.Exit(1)
 			}
-			fmt.Printf("New authentication token generated: %s\n", token)
+			fmt.Printf("New authentication token %s generated: %s\n", name, token)
+			fmt.Printf("The previous tokens remain valid for %v\n", grace)
+
+		case "add-token":
+			name := requireArg(2, "token name")
+			token, err := config.AddToken(name, parseDurationArg(3, 0))
+			if err != nil {
+				fmt.Printf("Error adding token: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Authentication token %s added: %s\n", name, token)
+
+		case "list-tokens":
+			cnf, err := config.GetConfig()
+			if err != nil {
+				fmt.Printf("Error reading config: %v\n", err)
+				os.Exit(1)
+			}
+			listTokens(cnf)
+
+		case "expire-token":
+			name := requireArg(2, "token name")
+			grace := parseDurationArg(3, config.DefaultGracePeriod)
+			if err := config.ExpireToken(name, grace); err != nil {
+				fmt.Printf("Error expiring token: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Token %s expires in %v\n", name, grace)
+
+		case "revoke-token":
+			name := requireArg(2, "token name")
+			if err := config.RevokeToken(name); err != nil {
+				fmt.Printf("Error revoking token: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Token %s revoked\n", name)
+
+		case "add-webhook":
+			name := requireArg(2, "token name")
+			webhook := requireArg(3, "webhook URL")
+			secret, err := config.AddWebhook(name, webhook)
+			if err != nil {
+				fmt.Printf("Error adding webhook: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Webhook %s added to token %s\n", webhook, name)
+			if secret != "" {
+				fmt.Printf("Webhooks of token %s are signed with: %s\n", name, secret)
+			}
+
+		case "remove-webhook":
+			name := requireArg(2, "token name")
+			webhook := requireArg(3, "webhook URL")
+			if err := config.RemoveWebhook(name, webhook); err != nil {
+				fmt.Printf("Error removing webhook: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Webhook %s removed from token %s\n", webhook, name)
+
+		case "generate-webhook-secret":
+			name := requireArg(2, "token name")
+			secret, err := config.RotateWebhookSecret(name)
+			if err != nil {
+				fmt.Printf("Error generating webhook secret: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Webhooks of token %s are now signed with: %s\n", name, secret)
 
 		case "uninstall":
 			fmt.Println("Uninstalling SignerServiceServer service ...")
@@ -82,6 +150,48 @@ func main() {
 	}
 }
 
+// requireArg returns the command argument at index, exiting when it is
+// missing.
+func requireArg(index int, name string) string {
+	if len(os.Args) <= index {
+		fmt.Printf("Missing %s\n", name)
+		os.Exit(1)
+	}
+	return os.Args[index]
+}
+
+// parseDurationArg returns the duration given at index, or fallback when it
+// is missing.
+func parseDurationArg(index int, fallback time.Duration) time.Duration {
+	if len(os.Args) <= index {
+		return fallback
+	}
+	d, err := time.ParseDuration(os.Args[index])
+	if err != nil {
+		fmt.Printf("Invalid duration: %s\n", os.Args[index])
+		os.Exit(1)
+	}
+	return d
+}
+
+func listTokens(cnf *config.Config) {
+	now := time.Now()
+	for _, t := range cnf.AllTokens() {
+		status := "active"
+		switch {
+		case t.Expired(now):
+			status = "expired " + t.ExpiresAt.Local().Format(time.RFC3339)
+		case !t.ExpiresAt.IsZero():
+			status = "expires " + t.ExpiresAt.Local().Format(time.RFC3339)
+		}
+		created := "-"
+		if !t.CreatedAt.IsZero() {
+			created = t.CreatedAt.Local().Format(time.RFC3339)
+		}
+		fmt.Printf("%s\tcreated %s\t%s\n", t.Name, created, status)
+	}
+}
+
 func Help() {
 	fmt.Println("### SignerServiceServer CLI ###")
 	fmt.Println()
@@ -91,7 +201,14 @@ func Help() {
 	fmt.Println("Commands:")
 	fmt.Println("  install                  Install the SignerServiceServer as a system service")
 	fmt.Println("  run                      Run the SignerServiceServer in the foreground")
-	fmt.Println("  generate-new-token       Generate a new authentication token")
+	fmt.Println("  generate-new-token [grace] Add a new token and expire the others after grace (default 24h)")
+	fmt.Println("  add-token <name> [ttl]   Add a token, valid for ttl when given")
+	fmt.Println("  list-tokens              List the tokens with their creation and expiry dates")
+	fmt.Println("  expire-token <name> [grace] Expire a token after grace (default 24h, 0 for now)")
+	fmt.Println("  revoke-token <name>      Remove a token at once")
+	fmt.Println("  add-webhook <name> <url> Call url when a job uploaded with the token finishes")
+	fmt.Println("  remove-webhook <name> <url> Stop calling url for the token's jobs")
+	fmt.Println("  generate-webhook-secret <name> Replace the HMAC key of the token's webhooks")
 	fmt.Println("  uninstall                Uninstall the SignerServiceServer service")
 	fmt.Println("  help                     Display this help message")
 	fmt.Println()
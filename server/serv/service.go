@@ -25,8 +25,13 @@ func (p *program) Stop(_ service.Service) error {
 func (p *program) run() {
 	utils.InitLogger(config.ServiceLogFile)
 
-	srv := server.NewServer()
-	err := srv.Start("50052", "8081")
+	cnf, err := config.LoadConfig()
+	if err != nil {
+		utils.Logger.Fatal("error loading configuration: %v", err)
+	}
+
+	srv := server.NewServer(cnf)
+	err = srv.Start()
 	if err != nil {
 		utils.Logger.Fatal("error starting server: %v", err)
 	}
@@ -0,0 +1,406 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/YHVCorp/signer-service/proto"
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+const (
+	defaultLeaseTimeout  = 10 * time.Minute
+	defaultMaxAttempts   = 3
+	leaseMonitorInterval = 15 * time.Second
+)
+
+type clientSession struct {
+	id          string
+	queue       []*signJob // assigned to this client but not yet sent
+	cancels     []string   // cancelled jobs the client has to be told about
+	notify      chan struct{}
+	activeJobs  int
+	connectedAt time.Time
+	interactive bool            // the client uses JobSession and accepts cancellations
+	profiles    map[string]bool // signing profiles the client holds besides its default one
+	capacity    int             // jobs the client works on at the same time, 0 when unlimited
+}
+
+// signJob tracks a sign request from the moment it is queued until the
+// owning client acknowledges it. A job is leased once it has been sent on the
+// client stream; the lease ends with ReportSignResult or the signed upload.
+type signJob struct {
+	request      *proto.SignRequest
+	session      *clientSession
+	attempts     int
+	leaseExpires time.Time
+	downloadPath string
+	uploadPath   string
+}
+
+func (j *signJob) leased() bool {
+	return !j.leaseExpires.IsZero()
+}
+
+func newClientSession(clientID string, profiles []string) *clientSession {
+	session := &clientSession{
+		id:          clientID,
+		notify:      make(chan struct{}, 1),
+		connectedAt: time.Now(),
+		profiles:    make(map[string]bool, len(profiles)),
+	}
+	for _, profile := range profiles {
+		session.profiles[profile] = true
+	}
+	return session
+}
+
+// holds reports whether the client can sign with the given profile. Every
+// client signs jobs without a profile with its default certificate.
+func (cs *clientSession) holds(profile string) bool {
+	return profile == "" || cs.profiles[profile]
+}
+
+// saturated reports whether the client already has as many jobs as it
+// announced it can work on.
+func (cs *clientSession) saturated() bool {
+	return cs.capacity > 0 && cs.activeJobs >= cs.capacity
+}
+
+// profileList returns the profiles of the client in name order.
+func (cs *clientSession) profileList() []string {
+	profiles := make([]string, 0, len(cs.profiles))
+	for profile := range cs.profiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+func (cs *clientSession) wake() {
+	select {
+	case cs.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *SignerServer) registerClient(session *clientSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if previous, exists := s.clients[session.id]; exists {
+		utils.Logger.Info("Client %s reconnected, replacing previous stream", session.id)
+		s.requeueLocked(previous)
+	}
+	s.clients[session.id] = session
+
+	if len(session.profiles) > 0 {
+		utils.Logger.Info("Client %s connected with profiles %s, %d pending sign requests",
+			session.id, strings.Join(session.profileList(), ", "), len(s.pending))
+	} else {
+		utils.Logger.Info("Client %s connected, %d pending sign requests", session.id, len(s.pending))
+	}
+	if session.capacity > 0 {
+		utils.Logger.Info("Client %s works on up to %d sign requests at the same time", session.id, session.capacity)
+	}
+
+	s.dispatchLocked()
+}
+
+func (s *SignerServer) unregisterClient(session *clientSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A newer stream with the same identity may have replaced this one
+	if current, exists := s.clients[session.id]; exists && current == session {
+		delete(s.clients, session.id)
+	}
+	s.requeueLocked(session)
+
+	for _, job := range s.jobs {
+		if job.session == session && job.leased() {
+			s.expireLeaseLocked(job, fmt.Sprintf("client %s disconnected", session.id))
+		}
+	}
+
+	utils.Logger.Info("Client %s disconnected", session.id)
+
+	s.dispatchLocked()
+}
+
+// requeueLocked moves jobs that were assigned to the session but never
+// delivered back to the front of the pending queue. Callers must hold s.mu.
+func (s *SignerServer) requeueLocked(session *clientSession) {
+	if len(session.queue) == 0 {
+		return
+	}
+
+	requeued := make([]*signJob, 0, len(session.queue))
+	for _, job := range session.queue {
+		job.session = nil
+		if s.listener != nil {
+			if err := s.listener.JobRequeued(job.request.RequestId, fmt.Sprintf("client %s disconnected", session.id)); err != nil {
+				utils.Logger.ErrorF("Dropping sign request %s: %v", job.request.RequestId, err)
+				delete(s.jobs, job.request.RequestId)
+				continue
+			}
+		}
+		requeued = append(requeued, job)
+	}
+	session.activeJobs -= len(session.queue)
+	if session.activeJobs < 0 {
+		session.activeJobs = 0
+	}
+
+	s.pending = append(requeued, s.pending...)
+	session.queue = nil
+}
+
+// expireLeaseLocked takes a delivered job away from its client and puts it
+// back in the pending queue, or fails it once it has used all its attempts.
+// Callers must hold s.mu.
+func (s *SignerServer) expireLeaseLocked(job *signJob, reason string) {
+	if job.session != nil && job.session.activeJobs > 0 {
+		job.session.activeJobs--
+	}
+	job.session = nil
+	job.leaseExpires = time.Time{}
+
+	requestID := job.request.RequestId
+	if job.attempts >= s.maxAttempts {
+		delete(s.jobs, requestID)
+		message := fmt.Sprintf("%s after %d attempts", reason, job.attempts)
+		utils.Logger.ErrorF("Sign request %s failed: %s", requestID, message)
+		if s.listener != nil {
+			if err := s.listener.JobFailed(requestID, message); err != nil {
+				utils.Logger.ErrorF("Failed to mark request %s as failed: %v", requestID, err)
+			}
+		}
+		return
+	}
+
+	message := fmt.Sprintf("%s (attempt %d of %d)", reason, job.attempts, s.maxAttempts)
+	if s.listener != nil {
+		if err := s.listener.JobRequeued(requestID, message); err != nil {
+			utils.Logger.ErrorF("Dropping sign request %s: %v", requestID, err)
+			delete(s.jobs, requestID)
+			return
+		}
+	}
+
+	utils.Logger.Info("Sign request %s requeued: %s", requestID, message)
+	s.pending = append([]*signJob{job}, s.pending...)
+}
+
+// dispatchLocked assigns pending jobs, in queue order, to connected clients
+// holding their signing profile and below their capacity. Jobs no connected
+// client can take stay pending without holding up the others. Callers must
+// hold s.mu.
+func (s *SignerServer) dispatchLocked() {
+	if len(s.clients) == 0 {
+		return
+	}
+
+	pending := s.pending
+	s.pending = nil
+	for _, job := range pending {
+		session := s.pickClient(job.request.Profile)
+		if session == nil {
+			s.pending = append(s.pending, job)
+			continue
+		}
+
+		if s.listener != nil {
+			if err := s.listener.JobDispatched(job.request.RequestId, session.id); err != nil {
+				utils.Logger.ErrorF("Dropping sign request %s: %v", job.request.RequestId, err)
+				delete(s.jobs, job.request.RequestId)
+				continue
+			}
+		}
+
+		job.session = session
+		session.queue = append(session.queue, job)
+		session.activeJobs++
+		session.wake()
+
+		utils.Logger.Info("Sign request for file %s assigned to client %s", job.request.FileName, session.id)
+	}
+}
+
+// pickClient returns the connected client holding profile with the fewest
+// active jobs, rotating the starting point so ties are spread round-robin.
+// Saturated clients are skipped. Callers must hold s.mu.
+func (s *SignerServer) pickClient(profile string) *clientSession {
+	if len(s.clients) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(s.clients))
+	for id := range s.clients {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var selected *clientSession
+	start := s.nextIndex % len(ids)
+	for i := range ids {
+		session := s.clients[ids[(start+i)%len(ids)]]
+		if !session.holds(profile) || session.saturated() {
+			continue
+		}
+		if selected == nil || session.activeJobs < selected.activeJobs {
+			selected = session
+		}
+	}
+	if selected != nil {
+		s.nextIndex++
+	}
+
+	return selected
+}
+
+// profileAvailableLocked reports whether a connected client holds profile.
+// Callers must hold s.mu.
+func (s *SignerServer) profileAvailableLocked(profile string) bool {
+	for _, session := range s.clients {
+		if session.holds(profile) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextRequest pops the next job assigned to the session and starts its lease.
+func (s *SignerServer) nextRequest(session *clientSession) *signJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(session.queue) == 0 {
+		return nil
+	}
+
+	job := session.queue[0]
+	session.queue = session.queue[1:]
+
+	job.attempts++
+	job.leaseExpires = time.Now().Add(s.leaseTimeout)
+
+	// The transfer URLs are only valid for this client and this lease
+	job.request.DownloadUrl = s.urls.signPath(job.downloadPath, urlActionDownload, job.request.RequestId, session.id, job.leaseExpires)
+	job.request.UploadUrl = s.urls.signPath(job.uploadPath, urlActionUpload, job.request.RequestId, session.id, job.leaseExpires)
+
+	return job
+}
+
+// returnRequest puts back a job whose delivery failed so it is requeued when
+// the session is unregistered.
+func (s *SignerServer) returnRequest(session *clientSession, job *signJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job.attempts--
+	job.leaseExpires = time.Time{}
+	session.queue = append([]*signJob{job}, session.queue...)
+}
+
+// releaseJob acknowledges a job. When clientID is not empty the job is only
+// released if that client currently owns it.
+func (s *SignerServer) releaseJob(requestID, clientID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[requestID]
+	if !exists {
+		return false
+	}
+
+	if clientID != "" && (job.session == nil || job.session.id != clientID) {
+		utils.Logger.ErrorF("Ignoring result for request %s from client %s: job is not leased to it", requestID, clientID)
+		return false
+	}
+
+	delete(s.jobs, requestID)
+
+	if job.session != nil {
+		job.session.queue = removeJob(job.session.queue, job)
+		if job.session.activeJobs > 0 {
+			job.session.activeJobs--
+		}
+	} else {
+		s.pending = removeJob(s.pending, job)
+	}
+
+	s.dispatchLocked()
+
+	return true
+}
+
+// CompleteJob releases the lease of a job whose signed file has been received.
+func (s *SignerServer) CompleteJob(requestID string) {
+	s.releaseJob(requestID, "")
+}
+
+// CancelJob removes a job from the queue or from the client holding it. A
+// client connected through JobSession is told to stop working on it.
+func (s *SignerServer) CancelJob(requestID string) {
+	s.mu.RLock()
+	var session *clientSession
+	if job, exists := s.jobs[requestID]; exists && job.leased() {
+		session = job.session
+	}
+	s.mu.RUnlock()
+
+	if !s.releaseJob(requestID, "") {
+		return
+	}
+	utils.Logger.Info("Sign request %s cancelled", requestID)
+
+	if session != nil && session.interactive {
+		s.mu.Lock()
+		session.cancels = append(session.cancels, requestID)
+		s.mu.Unlock()
+		session.wake()
+	}
+}
+
+func (s *SignerServer) monitorLeases() {
+	ticker := time.NewTicker(leaseMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.expireLeases(now)
+		}
+	}
+}
+
+// expireLeases requeues or fails the jobs whose lease ended before now.
+func (s *SignerServer) expireLeases(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, job := range s.jobs {
+		if job.leased() && now.After(job.leaseExpires) {
+			s.expireLeaseLocked(job, "lease expired")
+		}
+	}
+	s.dispatchLocked()
+}
+
+func removeJob(jobs []*signJob, job *signJob) []*signJob {
+	for i, j := range jobs {
+		if j == job {
+			return append(jobs[:i], jobs[i+1:]...)
+		}
+	}
+	return jobs
+}
+
+func sortClients(clients []ClientInfo) {
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// jobRecorder is a JobListener recording the decisions about each job.
+type jobRecorder struct {
+	dispatched map[string]string // job -> client
+	failed     map[string]string // job -> message
+}
+
+func (r *jobRecorder) JobDispatched(requestID, clientID string) error {
+	r.dispatched[requestID] = clientID
+	return nil
+}
+
+func (r *jobRecorder) JobRequeued(requestID, reason string) error {
+	return nil
+}
+
+func (r *jobRecorder) JobFailed(requestID, message string) error {
+	r.failed[requestID] = message
+	return nil
+}
+
+func (r *jobRecorder) JobProgress(requestID string, progress JobProgress) error {
+	return nil
+}
+
+// newTestSignerServer returns a server whose decisions are recorded.
+func newTestSignerServer(t *testing.T) (*SignerServer, *jobRecorder) {
+	t.Helper()
+
+	s := NewSignerServer()
+	jobs := &jobRecorder{dispatched: map[string]string{}, failed: map[string]string{}}
+	s.SetJobListener(jobs)
+	return s, jobs
+}
+
+func sendRequests(s *SignerServer, ids ...string) {
+	for _, id := range ids {
+		s.SendSignRequest(id, id+".exe", "", "", "/download/"+id, "/upload/"+id)
+	}
+}
+
+func TestClientsRegisterUnderTheirIdentity(t *testing.T) {
+	s, _ := newTestSignerServer(t)
+
+	first := newClientSession("build-01", nil)
+	second := newClientSession("build-02", nil)
+	s.registerClient(first)
+	s.registerClient(second)
+
+	clients := s.GetClients()
+	if len(clients) != 2 || clients[0].ID != "build-01" || clients[1].ID != "build-02" {
+		t.Fatalf("GetClients() = %+v, want build-01 and build-02", clients)
+	}
+
+	// A reconnect replaces the previous stream of the same client, and the
+	// old stream closing afterwards leaves the new one registered
+	reconnected := newClientSession("build-01", nil)
+	s.registerClient(reconnected)
+	s.unregisterClient(first)
+
+	if s.clients["build-01"] != reconnected {
+		t.Fatal("closing the replaced stream unregistered the new one")
+	}
+	if len(s.GetClients()) != 2 {
+		t.Fatalf("GetClients() = %+v, want two clients", s.GetClients())
+	}
+}
+
+func TestPendingJobsWaitForClients(t *testing.T) {
+	s, jobs := newTestSignerServer(t)
+	dispatched := jobs.dispatched
+
+	// Nothing is dropped while no client is connected
+	ids := []string{"job-1", "job-2", "job-3"}
+	sendRequests(s, ids...)
+	if s.PendingCount() != len(ids) || len(dispatched) != 0 {
+		t.Fatalf("%d pending and %d dispatched jobs, want %d pending", s.PendingCount(), len(dispatched), len(ids))
+	}
+
+	session := newClientSession("build-01", nil)
+	s.registerClient(session)
+
+	if s.PendingCount() != 0 {
+		t.Fatalf("%d pending jobs after a client connected, want 0", s.PendingCount())
+	}
+	// Jobs are delivered in queue order
+	for _, id := range ids {
+		req := s.nextRequest(session)
+		if req == nil || req.request.RequestId != id {
+			t.Fatalf("next request = %v, want %s", req, id)
+		}
+		if dispatched[id] != "build-01" {
+			t.Errorf("%s dispatched to %q, want build-01", id, dispatched[id])
+		}
+	}
+	if s.nextRequest(session) != nil {
+		t.Error("request delivered twice")
+	}
+}
+
+func TestDispatchToLeastBusyClient(t *testing.T) {
+	s, jobs := newTestSignerServer(t)
+	dispatched := jobs.dispatched
+
+	busy := newClientSession("busy", nil)
+	idle := newClientSession("idle", nil)
+	s.registerClient(busy)
+	s.registerClient(idle)
+	busy.activeJobs = 2
+
+	sendRequests(s, "job-1", "job-2", "job-3")
+	for id, want := range map[string]string{"job-1": "idle", "job-2": "idle"} {
+		if dispatched[id] != want {
+			t.Errorf("%s dispatched to %q, want %s", id, dispatched[id], want)
+		}
+	}
+	if idle.activeJobs+busy.activeJobs != 5 {
+		t.Errorf("clients hold %d and %d jobs, want 5 in total", idle.activeJobs, busy.activeJobs)
+	}
+
+	s.releaseJob("job-1", "")
+	if idle.activeJobs+busy.activeJobs != 4 {
+		t.Errorf("clients hold %d and %d jobs after a release, want 4 in total", idle.activeJobs, busy.activeJobs)
+	}
+	// Releasing a job twice changes nothing
+	s.releaseJob("job-1", "")
+	if idle.activeJobs+busy.activeJobs != 4 {
+		t.Errorf("clients hold %d and %d jobs after a second release, want 4 in total", idle.activeJobs, busy.activeJobs)
+	}
+}
+
+func TestDisconnectRequeuesLeasedJobs(t *testing.T) {
+	s, jobs := newTestSignerServer(t)
+	dispatched := jobs.dispatched
+
+	session := newClientSession("build-01", nil)
+	s.registerClient(session)
+	sendRequests(s, "delivered", "queued-1", "queued-2")
+	if s.nextRequest(session) == nil {
+		t.Fatal("job not delivered")
+	}
+
+	s.unregisterClient(session)
+	if s.PendingCount() != 3 {
+		t.Fatalf("%d pending jobs after disconnect, want all 3", s.PendingCount())
+	}
+	if attempts := s.jobs["delivered"].attempts; attempts != 1 {
+		t.Errorf("leased job attempts = %d, want 1", attempts)
+	}
+
+	// The requeued jobs go to the next client first, in their order
+	other := newClientSession("build-02", nil)
+	s.registerClient(other)
+	for _, id := range []string{"delivered", "queued-1", "queued-2"} {
+		if req := s.nextRequest(other); req == nil || req.request.RequestId != id {
+			t.Fatalf("next request = %v, want %s", req, id)
+		}
+		if dispatched[id] != "build-02" {
+			t.Errorf("%s dispatched to %q, want build-02", id, dispatched[id])
+		}
+	}
+}
+
+func TestLeaseTimeoutRequeuesUntilMaxAttempts(t *testing.T) {
+	s, jobs := newTestSignerServer(t)
+	s.leaseTimeout = time.Minute
+	s.maxAttempts = 2
+
+	session := newClientSession("client", nil)
+	s.registerClient(session)
+	sendRequests(s, "job")
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		job := s.nextRequest(session)
+		if job == nil {
+			t.Fatalf("attempt %d: job not assigned to the connected client", attempt)
+		}
+		if job.attempts != attempt {
+			t.Fatalf("attempts = %d, want %d", job.attempts, attempt)
+		}
+
+		// The lease is kept until it has run out
+		s.expireLeases(job.leaseExpires)
+		if !job.leased() || job.session != session {
+			t.Fatalf("attempt %d: lease expired early", attempt)
+		}
+
+		s.expireLeases(job.leaseExpires.Add(time.Second))
+	}
+
+	if message := jobs.failed["job"]; !strings.Contains(message, "lease expired after 2 attempts") {
+		t.Fatalf("failure message = %q, want the job failed after %d expired leases", message, s.maxAttempts)
+	}
+	if _, exists := s.jobs["job"]; exists {
+		t.Error("failed job still tracked by the server")
+	}
+	if session.activeJobs != 0 || len(session.queue) != 0 {
+		t.Errorf("client still holds %d active and %d queued jobs", session.activeJobs, len(session.queue))
+	}
+}
+
+func TestLeaseTimeoutRedispatchesWithNewLease(t *testing.T) {
+	s, _ := newTestSignerServer(t)
+
+	session := newClientSession("client", nil)
+	s.registerClient(session)
+	sendRequests(s, "job")
+	job := s.nextRequest(session)
+	if job == nil {
+		t.Fatal("job not assigned")
+	}
+	firstExpiry := job.leaseExpires
+
+	// The client keeps its stream open but never reports back
+	s.expireLeases(firstExpiry.Add(time.Second))
+
+	if job.leased() {
+		t.Fatal("job still leased after its lease expired")
+	}
+	if len(session.queue) != 1 || session.activeJobs != 1 {
+		t.Fatalf("client holds %d queued and %d active jobs, want the requeued job", len(session.queue), session.activeJobs)
+	}
+
+	if s.nextRequest(session) != job {
+		t.Fatal("requeued job not delivered again")
+	}
+	if job.attempts != 2 || job.leaseExpires.Before(firstExpiry) {
+		t.Errorf("attempts = %d, lease %v, want a second lease", job.attempts, job.leaseExpires)
+	}
+
+	// Only the client holding the lease can release the job
+	if s.releaseJob("job", "other") {
+		t.Error("a client without the lease released the job")
+	}
+	if !s.releaseJob("job", "client") {
+		t.Error("the client holding the lease could not release the job")
+	}
+	if session.activeJobs != 0 {
+		t.Errorf("client holds %d active jobs after release", session.activeJobs)
+	}
+}
+
+func TestProfileJobsWaitForHolder(t *testing.T) {
+	s, jobs := newTestSignerServer(t)
+	dispatched := jobs.dispatched
+
+	s.registerClient(newClientSession("ov", nil))
+	s.SendSignRequest("driver", "driver.sys", "", "ev", "/download/driver", "/upload/driver")
+	s.SendSignRequest("tool", "tool.exe", "", "", "/download/tool", "/upload/tool")
+
+	// The job of the missing profile does not hold up the other one
+	if _, ok := dispatched["driver"]; ok {
+		t.Fatalf("ev job dispatched to %q without an ev client", dispatched["driver"])
+	}
+	if dispatched["tool"] != "ov" {
+		t.Fatalf("job without profile dispatched to %q, want ov", dispatched["tool"])
+	}
+
+	s.registerClient(newClientSession("ev", []string{"ev"}))
+	if dispatched["driver"] != "ev" {
+		t.Fatalf("ev job dispatched to %q, want ev", dispatched["driver"])
+	}
+}
+
+func TestPendingJobsWaitForCapacity(t *testing.T) {
+	s, jobs := newTestSignerServer(t)
+	dispatched := jobs.dispatched
+
+	first := newClientSession("build-01", nil)
+	first.capacity = 1
+	second := newClientSession("build-02", nil)
+	second.capacity = 2
+	s.registerClient(first)
+	s.registerClient(second)
+	sendRequests(s, "job-1", "job-2", "job-3", "job-4")
+
+	if first.activeJobs != 1 || second.activeJobs != 2 {
+		t.Fatalf("clients hold %d and %d jobs, want their capacity", first.activeJobs, second.activeJobs)
+	}
+	if s.PendingCount() != 1 {
+		t.Fatalf("%d pending jobs, want 1 above the clients' capacity", s.PendingCount())
+	}
+	// Jobs are dispatched in queue order
+	if _, ok := dispatched["job-4"]; ok {
+		t.Errorf("last job dispatched to %q, want it pending", dispatched["job-4"])
+	}
+
+	// A finished job frees a slot for the queue
+	job := s.nextRequest(first)
+	if job == nil {
+		t.Fatal("no job delivered to build-01")
+	}
+	s.CompleteJob(job.request.RequestId)
+
+	if s.PendingCount() != 0 {
+		t.Fatalf("%d pending jobs after a job completed, want 0", s.PendingCount())
+	}
+	if dispatched["job-4"] != "build-01" {
+		t.Errorf("last job dispatched to %q, want build-01", dispatched["job-4"])
+	}
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxStatusWait    = 5 * time.Minute
+	eventsKeepAlive  = 15 * time.Second
+	statusEventName  = "status"
+	keepAliveComment = ": keep-alive\n\n"
+)
+
+// statusLocked builds the status reported for the job. Callers must hold
+// fm.mu.
+func statusLocked(fileInfo *FileInfo) StatusResponse {
+	return StatusResponse{
+		Status:       fileInfo.Status,
+		ClientID:     fileInfo.ClientID,
+		Message:      fileInfo.Message,
+		Profile:      fileInfo.Profile,
+		Sha256:       fileInfo.Sha256,
+		SignedSha256: fileInfo.SignedSha256,
+		TimestampURL: fileInfo.TimestampURL,
+		Unverified:   fileInfo.Unverified,
+		Progress:     fileInfo.Progress,
+	}
+}
+
+// statusWatcher queues the status changes of a job for one subscriber, so a
+// slow reader still receives every transition in order. Progress updates
+// within the same state replace each other, which bounds the queue by the
+// number of transitions.
+type statusWatcher struct {
+	// queue and closed are guarded by fm.mu
+	queue  []StatusResponse
+	closed bool
+	// ready is signalled when the queue or closed changes
+	ready chan struct{}
+}
+
+// watch subscribes to the status changes of a job and returns its current
+// status.
+func (fm *FileManager) watch(fileID string) (StatusResponse, *statusWatcher, error) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fileInfo, exists := fm.files[fileID]
+	if !exists {
+		return StatusResponse{}, nil, errFileNotFound
+	}
+
+	w := &statusWatcher{ready: make(chan struct{}, 1)}
+	fm.watchers[fileID] = append(fm.watchers[fileID], w)
+
+	return statusLocked(fileInfo), w, nil
+}
+
+func (fm *FileManager) unwatch(fileID string, w *statusWatcher) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	watchers := fm.watchers[fileID]
+	for i, other := range watchers {
+		if other == w {
+			watchers = append(watchers[:i], watchers[i+1:]...)
+			break
+		}
+	}
+	if len(watchers) == 0 {
+		delete(fm.watchers, fileID)
+	} else {
+		fm.watchers[fileID] = watchers
+	}
+}
+
+// nextStatuses returns the statuses queued for the watcher, and false once
+// the job has been removed and no status is left.
+func (fm *FileManager) nextStatuses(w *statusWatcher) ([]StatusResponse, bool) {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	queue := w.queue
+	w.queue = nil
+	return queue, len(queue) > 0 || !w.closed
+}
+
+// notifyLocked queues the current status of the job for its watchers.
+// Callers must hold fm.mu.
+func (fm *FileManager) notifyLocked(fileInfo *FileInfo) {
+	response := statusLocked(fileInfo)
+	for _, w := range fm.watchers[fileInfo.ID] {
+		if n := len(w.queue); n > 0 && w.queue[n-1].Status == response.Status {
+			w.queue[n-1] = response
+		} else {
+			w.queue = append(w.queue, response)
+		}
+		w.signal()
+	}
+}
+
+// closeWatchersLocked ends the subscriptions of a job that is being removed.
+// Callers must hold fm.mu.
+func (fm *FileManager) closeWatchersLocked(fileID string) {
+	for _, w := range fm.watchers[fileID] {
+		w.closed = true
+		w.signal()
+	}
+	delete(fm.watchers, fileID)
+}
+
+func (w *statusWatcher) signal() {
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// parseWait reads the wait parameter of the status endpoint, either a Go
+// duration such as 60s or a number of seconds.
+func parseWait(value string) (time.Duration, bool) {
+	wait, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, false
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	if wait < 0 {
+		return 0, false
+	}
+	if wait > maxStatusWait {
+		wait = maxStatusWait
+	}
+	return wait, true
+}
+
+// waitForStatus is the long-poll variant of the status endpoint. It answers
+// as soon as the job reaches a terminal state, or with the current status once
+// the wait is over.
+func (fm *FileManager) waitForStatus(c *gin.Context, fileID string, wait time.Duration) {
+	response, w, err := fm.watch(fileID)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	defer fm.unwatch(fileID, w)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for !response.Status.IsTerminal() {
+		select {
+		case <-w.ready:
+			queue, ok := fm.nextStatuses(w)
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+				return
+			}
+			if len(queue) > 0 {
+				response = queue[len(queue)-1]
+			}
+		case <-timer.C:
+			c.JSON(http.StatusOK, response)
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// streamJobEvents sends the status of a job as Server-Sent Events, starting
+// with the current one, and ends the stream once the job reaches a terminal
+// state.
+func (fm *FileManager) streamJobEvents(c *gin.Context) {
+	fileID := c.Param("file_id")
+
+	response, w, err := fm.watch(fileID)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	defer fm.unwatch(fileID, w)
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.SSEvent(statusEventName, response)
+	c.Writer.Flush()
+	if response.Status.IsTerminal() {
+		return
+	}
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(out io.Writer) bool {
+		select {
+		case <-w.ready:
+			queue, ok := fm.nextStatuses(w)
+			for _, next := range queue {
+				c.SSEvent(statusEventName, next)
+				if next.Status.IsTerminal() {
+					return false
+				}
+			}
+			return ok
+		case <-keepAlive.C:
+			_, err := io.WriteString(out, keepAliveComment)
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatcherReceivesEveryTransition(t *testing.T) {
+	fm := newTestFileManager(t)
+	fm.files["job"] = &FileInfo{ID: "job", Status: StatusQueued}
+
+	current, w, err := fm.watch("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fm.unwatch("job", w)
+	if current.Status != StatusQueued {
+		t.Fatalf("watch() status = %s, want %s", current.Status, StatusQueued)
+	}
+
+	// Nothing is read until the job has finished, as with a slow subscriber
+	if err := fm.JobDispatched("job", "client"); err != nil {
+		t.Fatal(err)
+	}
+	for _, phase := range []string{"downloading", "downloading", "signing", "timestamping"} {
+		if err := fm.JobProgress("job", JobProgress{Phase: phase, UpdatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, status := range []JobStatus{StatusUploaded, StatusReady} {
+		if err := fm.transition("job", status, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-w.ready:
+	default:
+		t.Fatal("watcher was not signalled")
+	}
+	queue, ok := fm.nextStatuses(w)
+	if !ok {
+		t.Fatal("nextStatuses() reported a closed watcher")
+	}
+
+	want := []JobStatus{StatusDispatched, StatusDownloading, StatusSigning, StatusUploaded, StatusReady}
+	if len(queue) != len(want) {
+		t.Fatalf("received %d statuses, want %d: %v", len(queue), len(want), queue)
+	}
+	for i, status := range want {
+		if queue[i].Status != status {
+			t.Errorf("status %d = %s, want %s", i, queue[i].Status, status)
+		}
+	}
+	// Progress within a state keeps only the latest update
+	if progress := queue[2].Progress; progress == nil || progress.Phase != "timestamping" {
+		t.Errorf("signing progress = %+v, want timestamping", progress)
+	}
+}
+
+func TestWatcherClosedWhenJobRemoved(t *testing.T) {
+	fm := newTestFileManager(t)
+	fm.files["job"] = &FileInfo{ID: "job", Status: StatusQueued}
+
+	_, w, err := fm.watch("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.transition("job", StatusCancelled, ""); err != nil {
+		t.Fatal(err)
+	}
+	fm.cleanupFile("job")
+
+	queue, ok := fm.nextStatuses(w)
+	if len(queue) != 1 || queue[0].Status != StatusCancelled {
+		t.Fatalf("nextStatuses() = %v, want the cancelled status", queue)
+	}
+	if !ok {
+		t.Fatal("nextStatuses() reported a closed watcher before its last status was read")
+	}
+	if queue, ok := fm.nextStatuses(w); ok || len(queue) != 0 {
+		t.Fatalf("nextStatuses() = %v, %v after removal, want closed", queue, ok)
+	}
+}
@@ -3,8 +3,10 @@ package server
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/YHVCorp/signer-service/proto"
 	"github.com/YHVCorp/signer-service/server/config"
@@ -13,72 +15,223 @@ import (
 	"google.golang.org/grpc/metadata"
 )
 
+const defaultClientID = "default"
+
 type SignerServer struct {
 	proto.UnimplementedSignerServiceServer
-	clients map[string]chan *proto.SignRequest
-	mu      sync.RWMutex
+	clients      map[string]*clientSession
+	jobs         map[string]*signJob
+	pending      []*signJob
+	nextIndex    int
+	listener     JobListener
+	files        fileTransfer
+	urls         *urlSigner
+	leaseTimeout time.Duration
+	maxAttempts  int
+	done         chan struct{}
+	mu           sync.RWMutex
+}
+
+// JobListener is notified when the SignerServer makes a decision about a job.
+// Returning an error rejects the transition and the job is dropped from the
+// queue.
+type JobListener interface {
+	JobDispatched(requestID, clientID string) error
+	JobRequeued(requestID, reason string) error
+	JobFailed(requestID, message string) error
+	JobProgress(requestID string, progress JobProgress) error
+}
+
+type ClientInfo struct {
+	ID          string    `json:"id"`
+	Profiles    []string  `json:"profiles,omitempty"`
+	Capacity    int       `json:"capacity,omitempty"`
+	ActiveJobs  int       `json:"active_jobs"`
+	QueuedJobs  int       `json:"queued_jobs"`
+	ConnectedAt time.Time `json:"connected_at"`
 }
 
 func NewSignerServer() *SignerServer {
 	return &SignerServer{
-		clients: make(map[string]chan *proto.SignRequest),
+		clients:      make(map[string]*clientSession),
+		jobs:         make(map[string]*signJob),
+		urls:         newURLSigner(),
+		leaseTimeout: defaultLeaseTimeout,
+		maxAttempts:  defaultMaxAttempts,
+		done:         make(chan struct{}),
 	}
 }
 
+// Start begins monitoring job leases so jobs held by unresponsive clients are
+// requeued.
+func (s *SignerServer) Start() {
+	go s.monitorLeases()
+}
+
+func (s *SignerServer) Stop() {
+	close(s.done)
+}
+
+func (s *SignerServer) SetJobListener(listener JobListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listener = listener
+}
+
 func (s *SignerServer) StreamSignRequests(req *proto.Empty, stream proto.SignerService_StreamSignRequestsServer) error {
 	if err := s.validateToken(stream.Context()); err != nil {
 		utils.Logger.ErrorF("authentication failed: %v", err)
 		return fmt.Errorf("authentication failed: %v", err)
 	}
 
-	clientID := "default"
-
-	clientChan := make(chan *proto.SignRequest, 100)
+	clientID := getClientID(stream.Context())
+	session := newClientSession(clientID, getClientProfiles(stream.Context()))
+	session.capacity = getClientCapacity(stream.Context())
 
-	s.mu.Lock()
-	s.clients[clientID] = clientChan
-	s.mu.Unlock()
+	s.registerClient(session)
+	defer s.unregisterClient(session)
 
-	defer func() {
-		s.mu.Lock()
-		delete(s.clients, clientID)
-		close(clientChan)
-		s.mu.Unlock()
-	}()
+	return s.serveSession(stream.Context(), session, stream.Send, nil)
+}
 
+// serveSession delivers the jobs assigned to the session, and cancellations
+// when the client supports them, until the stream ends.
+func (s *SignerServer) serveSession(ctx context.Context, session *clientSession, sendJob func(*proto.SignRequest) error, sendCancel func(requestID string) error) error {
 	for {
 		select {
-		case <-stream.Context().Done():
-			return stream.Context().Err()
-		case signReq := <-clientChan:
-			if err := stream.Send(signReq); err != nil {
-				return err
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-session.notify:
+			if sendCancel != nil {
+				for _, requestID := range s.takeCancellations(session) {
+					if err := sendCancel(requestID); err != nil {
+						return err
+					}
+					utils.Logger.Info("Cancellation of request %s sent to client %s", requestID, session.id)
+				}
+			}
+			for {
+				job := s.nextRequest(session)
+				if job == nil {
+					break
+				}
+				if err := sendJob(job.request); err != nil {
+					s.returnRequest(session, job)
+					return err
+				}
+				utils.Logger.Info("Sign request for file %s delivered to client %s", job.request.FileName, session.id)
 			}
 		}
 	}
 }
 
-func (s *SignerServer) SendSignRequest(clientID, requestID, fileName, downloadURL, uploadURL string) {
+// SendSignRequest queues the request and dispatches it as soon as a signing
+// client holding the profile is available. An empty profile is signed by any
+// client with its default certificate. Requests are never dropped when no
+// client is connected.
+func (s *SignerServer) SendSignRequest(requestID, fileName, sha256, profile, downloadURL, uploadURL string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job := &signJob{
+		request: &proto.SignRequest{
+			RequestId: requestID,
+			FileName:  fileName,
+			Sha256:    sha256,
+			Profile:   profile,
+		},
+		downloadPath: downloadURL,
+		uploadPath:   uploadURL,
+	}
+	s.jobs[requestID] = job
+	s.pending = append(s.pending, job)
+
+	if len(s.clients) == 0 {
+		utils.Logger.Info("No signing client connected, sign request for file %s queued (%d pending)", fileName, len(s.pending))
+	} else if !s.profileAvailableLocked(profile) {
+		utils.Logger.Info("No connected client holds profile %s, sign request for file %s queued (%d pending)", profile, fileName, len(s.pending))
+	}
+
+	s.dispatchLocked()
+}
+
+func (s *SignerServer) GetClients() []ClientInfo {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if clientChan, exists := s.clients[clientID]; exists {
-		signReq := &proto.SignRequest{
-			RequestId:   requestID,
-			FileName:    fileName,
-			DownloadUrl: downloadURL,
-			UploadUrl:   uploadURL,
-		}
+	clients := make([]ClientInfo, 0, len(s.clients))
+	for _, session := range s.clients {
+		clients = append(clients, ClientInfo{
+			ID:          session.id,
+			Profiles:    session.profileList(),
+			Capacity:    session.capacity,
+			ActiveJobs:  session.activeJobs,
+			QueuedJobs:  len(session.queue),
+			ConnectedAt: session.connectedAt,
+		})
+	}
+	sortClients(clients)
 
-		select {
-		case clientChan <- signReq:
-			utils.Logger.Info("Sign request sent for file %s", fileName)
-		default:
-			utils.Logger.ErrorF("Failed to send sign request for %s: channel full", fileName)
+	return clients
+}
+
+func (s *SignerServer) PendingCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.pending)
+}
+
+func getClientID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return defaultClientID
+	}
+
+	clientID := md.Get("client-id")
+	if len(clientID) == 0 || strings.TrimSpace(clientID[0]) == "" {
+		return defaultClientID
+	}
+
+	return strings.TrimSpace(clientID[0])
+}
+
+// getClientCapacity returns the number of jobs the client announced it can
+// work on at the same time in the client-capacity metadata, 0 when it did
+// not announce a limit.
+func getClientCapacity(ctx context.Context) int {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0
+	}
+
+	values := md.Get("client-capacity")
+	if len(values) == 0 {
+		return 0
+	}
+	capacity, err := strconv.Atoi(strings.TrimSpace(values[0]))
+	if err != nil || capacity < 0 {
+		return 0
+	}
+	return capacity
+}
+
+// getClientProfiles returns the signing profiles announced by the client in
+// the signing-profiles metadata, a comma separated list of names.
+func getClientProfiles(ctx context.Context) []string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	var profiles []string
+	for _, value := range md.Get("signing-profiles") {
+		for _, profile := range strings.Split(value, ",") {
+			if profile = strings.TrimSpace(profile); profile != "" {
+				profiles = append(profiles, profile)
+			}
 		}
-	} else {
-		utils.Logger.ErrorF("Client %s not found", clientID)
 	}
+	return profiles
 }
 
 func (s *SignerServer) validateToken(ctx context.Context) error {
@@ -95,13 +248,8 @@ func (s *SignerServer) validateToken(ctx context.Context) error {
 	token := authHeader[0]
 	token = strings.TrimPrefix(token, "Bearer ")
 
-	expectedToken, err := config.GetDecryptedToken()
-	if err != nil {
-		return fmt.Errorf("server configuration error: %v", err)
-	}
-
-	if token != expectedToken {
-		return fmt.Errorf("invalid token")
+	if _, err := config.ValidateToken(token); err != nil {
+		return err
 	}
 
 	return nil
@@ -112,11 +260,34 @@ func (s *SignerServer) ReportSignResult(ctx context.Context, result *proto.SignR
 		return nil, fmt.Errorf("authentication failed: %v", err)
 	}
 
-	utils.Logger.Info("Received sign result for request %s: success=%t, message=%s",
-		result.RequestId, result.Success, result.Message)
+	s.handleSignResult(getClientID(ctx), result.RequestId, result.Success, result.Message)
+
 	return &proto.Empty{}, nil
 }
 
+func (s *SignerServer) handleSignResult(clientID, requestID string, success bool, message string) {
+	utils.Logger.Info("Received sign result for request %s from client %s: success=%t, message=%s",
+		requestID, clientID, success, message)
+
+	if !s.releaseJob(requestID, clientID) || success {
+		return
+	}
+
+	if message == "" {
+		message = fmt.Sprintf("signing failed on client %s", clientID)
+	}
+
+	s.mu.RLock()
+	listener := s.listener
+	s.mu.RUnlock()
+
+	if listener != nil {
+		if err := listener.JobFailed(requestID, message); err != nil {
+			utils.Logger.ErrorF("Failed to mark request %s as failed: %v", requestID, err)
+		}
+	}
+}
+
 func (s *SignerServer) RegisterGRPC(grpcServer *grpc.Server) {
 	proto.RegisterSignerServiceServer(grpcServer, s)
 }
@@ -2,13 +2,17 @@ package server
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/YHVCorp/signer-service/server/config"
 	"github.com/YHVCorp/signer-service/server/utils"
@@ -17,34 +21,69 @@ import (
 
 type FileManager struct {
 	files       map[string]*FileInfo
+	watchers    map[string][]*statusWatcher
 	mu          sync.RWMutex
+	store       JobStore
+	webhooks    *WebhookNotifier
+	urls        *urlSigner
+	storePath   string
 	uploadDir   string
 	downloadDir string
 	sharedDir   string
+
+	maxUploadSize int64         // bytes accepted by /api/v1/upload, 0 for any
+	retention     time.Duration // how long finished jobs are kept, 0 for ever
+	maxPending    int           // queued jobs above which uploads are rejected, 0 for any
 }
 
 type FileInfo struct {
-	ID          string `json:"id"`
-	OriginalURL string `json:"original_url,omitempty"`
-	SignedURL   string `json:"signed_url,omitempty"`
-	Status      string `json:"status"` // "uploaded", "signing", "signed", "ready"
+	ID           string       `json:"id"`
+	OriginalURL  string       `json:"original_url,omitempty"`
+	SignedURL    string       `json:"signed_url,omitempty"`
+	Status       JobStatus    `json:"status"`
+	ClientID     string       `json:"client_id,omitempty"`
+	Message      string       `json:"message,omitempty"`
+	FileName     string       `json:"file_name"`
+	Profile      string       `json:"profile,omitempty"`
+	Sha256       string       `json:"sha256,omitempty"`
+	CallbackURL  string       `json:"callback_url,omitempty"`
+	Thumbprint   string       `json:"thumbprint,omitempty"`
+	TokenName    string       `json:"token_name,omitempty"`
+	SignedSha256 string       `json:"signed_sha256,omitempty"`
+	TimestampURL string       `json:"timestamp_url,omitempty"`
+	Unverified   bool         `json:"unverified,omitempty"`
+	Progress     *JobProgress `json:"progress,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
 }
 
 type UploadResponse struct {
 	FileID string `json:"file_id"`
+	Sha256 string `json:"sha256"`
 }
 
 type StatusResponse struct {
-	Status string `json:"status"`
+	Status       JobStatus    `json:"status"`
+	ClientID     string       `json:"client_id,omitempty"`
+	Message      string       `json:"message,omitempty"`
+	Profile      string       `json:"profile,omitempty"`
+	Sha256       string       `json:"sha256,omitempty"`
+	SignedSha256 string       `json:"signed_sha256,omitempty"`
+	TimestampURL string       `json:"timestamp_url,omitempty"`
+	Unverified   bool         `json:"unverified,omitempty"`
+	Progress     *JobProgress `json:"progress,omitempty"`
 }
 
-func NewFileManager() *FileManager {
-	basePath := utils.GetMyPath()
+func NewFileManager(storage config.StorageConfig) *FileManager {
 	return &FileManager{
 		files:       make(map[string]*FileInfo),
-		uploadDir:   filepath.Join(basePath, "uploads"),
-		downloadDir: filepath.Join(basePath, "downloads"),
-		sharedDir:   filepath.Join(basePath, "shared"),
+		watchers:    make(map[string][]*statusWatcher),
+		webhooks:    NewWebhookNotifier(),
+		urls:        newURLSigner(),
+		storePath:   storage.JobsDB,
+		uploadDir:   storage.Uploads,
+		downloadDir: storage.Downloads,
+		sharedDir:   storage.Shared,
 	}
 }
 
@@ -55,7 +94,29 @@ func (fm *FileManager) Setup() error {
 	if err := os.MkdirAll(fm.sharedDir, 0755); err != nil {
 		return err
 	}
-	return os.MkdirAll(fm.downloadDir, 0755)
+	if err := os.MkdirAll(fm.downloadDir, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fm.storePath), 0755); err != nil {
+		return err
+	}
+
+	if fm.store == nil {
+		store, err := NewBoltJobStore(fm.storePath)
+		if err != nil {
+			return err
+		}
+		fm.store = store
+	}
+
+	return fm.restoreJobs()
+}
+
+func (fm *FileManager) Close() error {
+	if fm.store == nil {
+		return nil
+	}
+	return fm.store.Close()
 }
 
 func (fm *FileManager) generateFileID() string {
@@ -70,13 +131,20 @@ func (fm *FileManager) SetupHTTPRoutes(router *gin.Engine, signerServer *SignerS
 
 	api.POST("/upload", fm.uploadFile(signerServer))
 	api.GET("/status/:file_id", fm.getFileStatus)
+	api.GET("/jobs/:file_id/events", fm.streamJobEvents)
 	api.GET("/download/:file_id", fm.downloadSignedFile)
-	api.POST("/upload-signed/:file_id", fm.uploadSignedFile)
-	api.POST("/finish/:file_id", fm.finishSignedFile)
+	api.POST("/upload-signed/:file_id", fm.uploadSignedFile(signerServer))
+	api.POST("/finish/:file_id", fm.finishSignedFile(signerServer))
+	api.POST("/cancel/:file_id", fm.cancelFile(signerServer))
 	api.GET("/fs/*filepath", fm.serveSharedFile)
+	api.GET("/clients", fm.listClients(signerServer))
 	router.GET("/unsigned/:file_id", fm.downloadUnsignedFile)
 }
 
+// tokenNameKey is the context key of the name of the token a request was
+// authenticated with.
+const tokenNameKey = "token_name"
+
 func (fm *FileManager) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("Authorization")
@@ -90,32 +158,80 @@ func (fm *FileManager) authMiddleware() gin.HandlerFunc {
 			token = token[7:]
 		}
 
-		expectedToken, err := config.GetDecryptedToken()
+		name, err := config.ValidateToken(token)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "server configuration error"})
-			c.Abort()
-			return
-		}
-
-		if token != expectedToken {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
 
+		// Jobs uploaded with this token use its webhooks and webhook secret
+		c.Set(tokenNameKey, name)
 		c.Next()
 	}
 }
 
 func (fm *FileManager) uploadFile(signerServer *SignerServer) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if fm.maxPending > 0 && signerServer.PendingCount() >= fm.maxPending {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "signing queue is full, retry later"})
+			return
+		}
+
+		if fm.maxUploadSize > 0 {
+			// Leave room for the multipart headers and the other form fields
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, fm.maxUploadSize+1<<20)
+		}
+
 		file, header, err := c.Request.FormFile("file")
 		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file larger than %d MiB", fm.maxUploadSize>>20)})
+				return
+			}
 			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file"})
 			return
 		}
 		defer file.Close()
 
+		if fm.maxUploadSize > 0 && header.Size > fm.maxUploadSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("file larger than %d MiB", fm.maxUploadSize>>20)})
+			return
+		}
+
+		callbackURL := c.PostForm("callback_url")
+		if callbackURL != "" {
+			if err := fm.webhooks.validateCallbackURL(callbackURL); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid callback_url: %v", err)})
+				return
+			}
+			// Webhooks are never sent unsigned
+			if _, secret, err := config.TokenWebhooks(c.GetString(tokenNameKey)); err != nil || secret == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "callback_url requires a webhook secret for the token, create one with generate-webhook-secret"})
+				return
+			}
+		}
+
+		profile := c.PostForm("profile")
+		if profile != "" && !validProfileName(profile) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile name"})
+			return
+		}
+
+		thumbprint := c.PostForm("thumbprint")
+		var requireThumbprint bool
+		if cnf, err := config.GetConfig(); err == nil {
+			if thumbprint == "" {
+				thumbprint = cnf.ExpectedThumbprint(profile)
+			}
+			requireThumbprint = cnf.RequireThumbprint
+		}
+		if requireThumbprint && normalizeThumbprint(thumbprint) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no signer thumbprint configured for this profile, set one in the server config or send thumbprint"})
+			return
+		}
+
 		fileID := fm.generateFileID()
 		filePath := filepath.Join(fm.uploadDir, fileID)
 
@@ -126,40 +242,97 @@ func (fm *FileManager) uploadFile(signerServer *SignerServer) gin.HandlerFunc {
 		}
 		defer outFile.Close()
 
-		_, err = io.Copy(outFile, file)
+		hasher := sha256.New()
+		_, err = io.Copy(io.MultiWriter(outFile, hasher), file)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save file"})
 			return
 		}
 
+		verifiable, err := isPEFile(filePath)
+		if err == nil && !verifiable && requireThumbprint {
+			err = errNotVerifiable
+		}
+		if err != nil {
+			outFile.Close()
+			os.Remove(filePath)
+			status := http.StatusBadRequest
+			if errors.Is(err, errNotVerifiable) {
+				status = http.StatusUnsupportedMediaType
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		fileName := header.Filename
+		if fileName == "" {
+			fileName = fileID
+		}
+
+		now := time.Now()
 		fileInfo := &FileInfo{
 			ID:          fileID,
 			OriginalURL: fmt.Sprintf("/unsigned/%s", fileID),
-			Status:      "uploaded",
+			Status:      StatusQueued,
+			FileName:    fileName,
+			Profile:     profile,
+			Sha256:      hex.EncodeToString(hasher.Sum(nil)),
+			CallbackURL: callbackURL,
+			Thumbprint:  thumbprint,
+			Unverified:  !verifiable,
+			TokenName:   c.GetString(tokenNameKey),
+			CreatedAt:   now,
+			UpdatedAt:   now,
 		}
 
 		fm.mu.Lock()
 		fm.files[fileID] = fileInfo
-		fileInfo.Status = "signing"
+		fm.persistLocked(fileInfo)
 		fm.mu.Unlock()
 
-		downloadEndpoint := fmt.Sprintf("/unsigned/%s", fileID)
-		uploadEndpoint := fmt.Sprintf("/api/v1/upload-signed/%s", fileID)
+		fm.enqueue(signerServer, fileInfo)
 
-		fileName := header.Filename
-		if fileName == "" {
-			fileName = fileID
-		}
+		c.JSON(http.StatusOK, UploadResponse{FileID: fileID, Sha256: fileInfo.Sha256})
+	}
+}
+
+func (fm *FileManager) enqueue(signerServer *SignerServer, fileInfo *FileInfo) {
+	downloadEndpoint := fmt.Sprintf("/unsigned/%s", fileInfo.ID)
+	uploadEndpoint := fmt.Sprintf("/api/v1/upload-signed/%s", fileInfo.ID)
 
-		signerServer.SendSignRequest("default", fileID, fileName, downloadEndpoint, uploadEndpoint)
+	signerServer.SendSignRequest(fileInfo.ID, fileInfo.FileName, fileInfo.Sha256, fileInfo.Profile, downloadEndpoint, uploadEndpoint)
+}
 
-		c.JSON(http.StatusOK, UploadResponse{FileID: fileID})
+// validProfileName reports whether name can be used as a signing profile:
+// letters, digits, dots, dashes and underscores, as clients announce their
+// profiles in a comma separated list.
+func validProfileName(name string) bool {
+	if len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+		default:
+			return false
+		}
 	}
+	return name != ""
 }
 
 func (fm *FileManager) getFileStatus(c *gin.Context) {
 	fileID := c.Param("file_id")
 
+	if value := c.Query("wait"); value != "" {
+		wait, ok := parseWait(value)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wait duration"})
+			return
+		}
+		fm.waitForStatus(c, fileID, wait)
+		return
+	}
+
 	fm.mu.RLock()
 	fileInfo, exists := fm.files[fileID]
 	fm.mu.RUnlock()
@@ -169,64 +342,216 @@ func (fm *FileManager) getFileStatus(c *gin.Context) {
 		return
 	}
 
-	response := StatusResponse{
-		Status: fileInfo.Status,
-	}
+	fm.mu.RLock()
+	response := statusLocked(fileInfo)
+	fm.mu.RUnlock()
 
 	c.JSON(http.StatusOK, response)
 }
 
 func (fm *FileManager) downloadUnsignedFile(c *gin.Context) {
 	fileID := c.Param("file_id")
-	filePath := filepath.Join(fm.uploadDir, fileID)
 
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+	if err := fm.verifySignedURL(c, urlActionDownload, fileID); err != nil {
+		respondJobError(c, err)
+		return
+	}
+
+	filePath, err := fm.beginUnsignedDownload(fileID)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read file"})
 		return
 	}
 
 	c.File(filePath)
+
+	// The job stays in downloading, and the client can fetch the file again,
+	// unless all of it was sent: the client may have gone away or asked for
+	// a range
+	if err := c.Request.Context().Err(); err != nil {
+		utils.Logger.ErrorF("Download of file %s interrupted: %v", fileID, err)
+		return
+	}
+	written := max(int64(c.Writer.Size()), 0)
+	if written != info.Size() || c.Writer.Status() != http.StatusOK {
+		utils.Logger.Info("Sent %d of %d bytes of file %s (status %d), not marking it as signing", written, info.Size(), fileID, c.Writer.Status())
+		return
+	}
+
+	fm.finishUnsignedDownload(fileID)
 }
 
-func (fm *FileManager) uploadSignedFile(c *gin.Context) {
-	fileID := c.Param("file_id")
+func (fm *FileManager) uploadSignedFile(signerServer *SignerServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fileID := c.Param("file_id")
 
+		if err := fm.verifySignedURL(c, urlActionUpload, fileID); err != nil {
+			respondJobError(c, err)
+			return
+		}
+
+		file, _, err := c.Request.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file"})
+			return
+		}
+		defer file.Close()
+
+		// Clients send the digest of the signed file so corruption in transit
+		// is caught before the job is marked ready
+		upload := signedUpload{
+			Sha256:       c.PostForm("sha256"),
+			TimestampURL: c.PostForm("timestamp_url"),
+		}
+		if upload.Sha256 == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing sha256"})
+			return
+		}
+
+		claimed, err := fm.storeSignedFile(fileID, upload, func(w io.Writer) error {
+			_, err := io.Copy(w, file)
+			return err
+		})
+		if claimed {
+			signerServer.CompleteJob(fileID)
+		}
+		if err != nil {
+			respondJobError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "signed file uploaded successfully"})
+	}
+}
+
+// beginUnsignedDownload moves the job to downloading and returns the path of
+// the unsigned file. A client retrying its download keeps the job in
+// downloading.
+func (fm *FileManager) beginUnsignedDownload(fileID string) (string, error) {
 	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
 	fileInfo, exists := fm.files[fileID]
 	if !exists {
-		fm.mu.Unlock()
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
-		return
+		return "", errFileNotFound
+	}
+	if fileInfo.Status != StatusDownloading {
+		if err := fm.transitionLocked(fileInfo, StatusDownloading, ""); err != nil {
+			return "", err
+		}
 	}
-	fm.mu.Unlock()
 
-	file, _, err := c.Request.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to get file"})
-		return
+	filePath := filepath.Join(fm.uploadDir, fileID)
+	if !utils.FileExists(filePath) {
+		return "", errFileNotFound
 	}
-	defer file.Close()
 
-	signedFilePath := filepath.Join(fm.downloadDir, fileID)
-	outFile, err := os.Create(signedFilePath)
+	return filePath, nil
+}
+
+func (fm *FileManager) finishUnsignedDownload(fileID string) {
+	if err := fm.transition(fileID, StatusSigning, ""); err != nil {
+		utils.Logger.ErrorF("Failed to mark file %s as signing: %v", fileID, err)
+	}
+}
+
+// signedUpload describes a signed file sent by a client.
+type signedUpload struct {
+	// Sha256 is the digest announced by the client, required on every
+	// transport
+	Sha256 string
+	// TimestampURL is the timestamp authority that timestamped the signature
+	TimestampURL string
+}
+
+// storeSignedFile saves the signed file produced by write and marks the job
+// ready. The file is written to a temporary path first, so an interrupted
+// transfer, or one that does not match the announced digest, leaves the job
+// untouched and the client can retry. The returned flag reports whether the
+// job was claimed by this upload and has therefore left the client's hands.
+func (fm *FileManager) storeSignedFile(fileID string, upload signedUpload, write func(w io.Writer) error) (bool, error) {
+	fm.mu.RLock()
+	fileInfo, exists := fm.files[fileID]
+	var status JobStatus
+	var thumbprint string
+	var unverified bool
+	if exists {
+		status, thumbprint, unverified = fileInfo.Status, fileInfo.Thumbprint, fileInfo.Unverified
+	}
+	fm.mu.RUnlock()
+
+	if !exists {
+		return false, errFileNotFound
+	}
+	if upload.Sha256 == "" {
+		return false, fmt.Errorf("missing sha256 of signed file")
+	}
+	// Reject early instead of receiving a file that cannot be accepted
+	if !status.CanTransitionTo(StatusUploaded) {
+		return false, &TransitionError{From: status, To: StatusUploaded}
+	}
+
+	tmpFile, err := os.CreateTemp(fm.downloadDir, fileID+".*.part")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save signed file"})
-		return
+		return false, fmt.Errorf("failed to save signed file: %v", err)
 	}
-	defer outFile.Close()
+	tmpPath := tmpFile.Name()
 
-	_, err = io.Copy(outFile, file)
+	hasher := sha256.New()
+	err = write(io.MultiWriter(tmpFile, hasher))
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save signed file"})
-		return
+		os.Remove(tmpPath)
+		return false, fmt.Errorf("failed to receive signed file: %v", err)
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if !strings.EqualFold(digest, upload.Sha256) {
+		os.Remove(tmpPath)
+		return false, &DigestError{Expected: upload.Sha256, Actual: digest}
+	}
+
+	// Claim the job so a second upload, or one for a cancelled or expired
+	// job, is rejected
+	if err := fm.transition(fileID, StatusUploaded, ""); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+
+	signedFilePath := filepath.Join(fm.downloadDir, fileID)
+	if err := os.Rename(tmpPath, signedFilePath); err != nil {
+		os.Remove(tmpPath)
+		fm.transition(fileID, StatusFailed, "failed to save signed file")
+		return true, fmt.Errorf("failed to save signed file: %v", err)
+	}
+
+	if unverified {
+		utils.Logger.Info("Accepted signed file %s without verification, as it is not a PE image", signedFilePath)
+	} else if err := verifySignedFile(filepath.Join(fm.uploadDir, fileID), signedFilePath, thumbprint); err != nil {
+		os.Remove(signedFilePath)
+		fm.transition(fileID, StatusFailed, err.Error())
+		return true, err
 	}
 
 	fm.mu.Lock()
-	fileInfo.Status = "ready"
+	defer fm.mu.Unlock()
+
+	fileInfo, exists = fm.files[fileID]
+	if !exists {
+		return true, errFileNotFound
+	}
 	fileInfo.SignedURL = signedFilePath
-	fm.mu.Unlock()
+	fileInfo.SignedSha256 = digest
+	fileInfo.TimestampURL = upload.TimestampURL
 
-	c.JSON(http.StatusOK, gin.H{"status": "signed file uploaded successfully"})
+	return true, fm.transitionLocked(fileInfo, StatusReady, "")
 }
 
 func (fm *FileManager) downloadSignedFile(c *gin.Context) {
@@ -241,7 +566,17 @@ func (fm *FileManager) downloadSignedFile(c *gin.Context) {
 		return
 	}
 
-	if fileInfo.Status != "ready" {
+	fm.mu.RLock()
+	status, message := fileInfo.Status, fileInfo.Message
+	unsignedSha256, signedSha256 := fileInfo.Sha256, fileInfo.SignedSha256
+	fm.mu.RUnlock()
+
+	if status == StatusFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": "signing failed", "message": message})
+		return
+	}
+
+	if status != StatusReady {
 		c.JSON(http.StatusNotFound, gin.H{"error": "signed file not ready"})
 		return
 	}
@@ -252,22 +587,38 @@ func (fm *FileManager) downloadSignedFile(c *gin.Context) {
 		return
 	}
 
+	c.Header("X-Unsigned-Sha256", unsignedSha256)
+	c.Header("X-Signed-Sha256", signedSha256)
 	c.File(signedFilePath)
 }
 
-func (fm *FileManager) finishSignedFile(c *gin.Context) {
-	fileID := c.Param("file_id")
-	fm.mu.RLock()
-	_, exists := fm.files[fileID]
-	fm.mu.RUnlock()
+func (fm *FileManager) finishSignedFile(signerServer *SignerServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fileID := c.Param("file_id")
 
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
-		return
+		if err := fm.transition(fileID, StatusExpired, "finished"); err != nil {
+			respondJobError(c, err)
+			return
+		}
+		signerServer.CancelJob(fileID)
+
+		go fm.cleanupFile(fileID)
+		c.JSON(http.StatusOK, gin.H{"status": "cleanup started"})
 	}
+}
+
+func (fm *FileManager) cancelFile(signerServer *SignerServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fileID := c.Param("file_id")
 
-	go fm.cleanupFile(fileID)
-	c.JSON(http.StatusOK, gin.H{"status": "cleanup started"})
+		if err := fm.transition(fileID, StatusCancelled, "cancelled by request"); err != nil {
+			respondJobError(c, err)
+			return
+		}
+		signerServer.CancelJob(fileID)
+
+		c.JSON(http.StatusOK, gin.H{"status": "job cancelled"})
+	}
 }
 
 func (fm *FileManager) serveSharedFile(c *gin.Context) {
@@ -313,9 +664,42 @@ func (fm *FileManager) serveSharedFile(c *gin.Context) {
 	c.File(fullPath)
 }
 
+func (fm *FileManager) listClients(signerServer *SignerServer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"clients": signerServer.GetClients(),
+			"pending": signerServer.PendingCount(),
+		})
+	}
+}
+
+func respondJobError(c *gin.Context, err error) {
+	var transitionErr *TransitionError
+	var digestErr *DigestError
+	var verificationErr *VerificationError
+	switch {
+	case errors.As(err, &transitionErr):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, errFileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
+	case errors.As(err, &digestErr), errors.As(err, &verificationErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, errURLSignature), errors.Is(err, errURLExpired), errors.Is(err, errNotJobOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
 func (fm *FileManager) cleanupFile(fileID string) {
 	fm.mu.Lock()
 	delete(fm.files, fileID)
+	fm.closeWatchersLocked(fileID)
+	if fm.store != nil {
+		if err := fm.store.Delete(fileID); err != nil {
+			utils.Logger.ErrorF("Failed to delete job %s from store: %v", fileID, err)
+		}
+	}
 	fm.mu.Unlock()
 
 	os.Remove(filepath.Join(fm.uploadDir, fileID))
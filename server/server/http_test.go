@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUnsignedDownloadMarksSigningOnlyWhenComplete(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		header string
+		cancel bool
+		want   JobStatus
+	}{
+		{name: "complete", want: StatusSigning},
+		{name: "range", header: "bytes=0-9", want: StatusDownloading},
+		{name: "client gone", cancel: true, want: StatusDownloading},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm := newTestFileManager(t)
+			fm.files["job"] = &FileInfo{ID: "job", Status: StatusDispatched, ClientID: "client"}
+			if err := os.WriteFile(filepath.Join(fm.uploadDir, "job"), make([]byte, 4096), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			signed := fm.urls.signPath("/unsigned/job", urlActionDownload, "job", "client", time.Now().Add(time.Minute))
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, signed, nil).WithContext(ctx)
+			if tt.header != "" {
+				c.Request.Header.Set("Range", tt.header)
+			}
+			c.Params = gin.Params{{Key: "file_id", Value: "job"}}
+
+			fm.downloadUnsignedFile(c)
+
+			if status := fm.files["job"].Status; status != tt.want {
+				t.Errorf("status = %s, want %s", status, tt.want)
+			}
+		})
+	}
+}
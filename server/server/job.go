@@ -0,0 +1,183 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	StatusQueued      JobStatus = "queued"
+	StatusDispatched  JobStatus = "dispatched"
+	StatusDownloading JobStatus = "downloading"
+	StatusSigning     JobStatus = "signing"
+	StatusUploaded    JobStatus = "uploaded"
+	StatusReady       JobStatus = "ready"
+	StatusFailed      JobStatus = "failed"
+	StatusCancelled   JobStatus = "cancelled"
+	StatusExpired     JobStatus = "expired"
+)
+
+// jobTransitions lists the states each state may move to. A job goes back to
+// queued when its client disconnects or its lease expires.
+var jobTransitions = map[JobStatus][]JobStatus{
+	StatusQueued:      {StatusDispatched, StatusFailed, StatusCancelled, StatusExpired},
+	StatusDispatched:  {StatusQueued, StatusDownloading, StatusUploaded, StatusFailed, StatusCancelled, StatusExpired},
+	StatusDownloading: {StatusQueued, StatusSigning, StatusUploaded, StatusFailed, StatusCancelled, StatusExpired},
+	StatusSigning:     {StatusQueued, StatusUploaded, StatusFailed, StatusCancelled, StatusExpired},
+	StatusUploaded:    {StatusReady, StatusFailed},
+	StatusReady:       {StatusExpired},
+	StatusFailed:      {StatusExpired},
+	StatusCancelled:   {StatusExpired},
+	StatusExpired:     {},
+}
+
+func (s JobStatus) CanTransitionTo(next JobStatus) bool {
+	for _, allowed := range jobTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTerminal reports whether the job has finished from the pipeline's point
+// of view.
+func (s JobStatus) IsTerminal() bool {
+	switch s {
+	case StatusReady, StatusFailed, StatusCancelled, StatusExpired:
+		return true
+	}
+	return false
+}
+
+// IsActive reports whether the job is owned by a signing client.
+func (s JobStatus) IsActive() bool {
+	switch s {
+	case StatusDispatched, StatusDownloading, StatusSigning:
+		return true
+	}
+	return false
+}
+
+var errFileNotFound = errors.New("file not found")
+
+type TransitionError struct {
+	From JobStatus
+	To   JobStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid job transition from %s to %s", e.From, e.To)
+}
+
+// DigestError reports a file whose SHA-256 does not match the one announced
+// by the sender.
+type DigestError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("sha256 mismatch: received %s, expected %s", e.Actual, e.Expected)
+}
+
+func (fm *FileManager) transition(fileID string, to JobStatus, message string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fileInfo, exists := fm.files[fileID]
+	if !exists {
+		return errFileNotFound
+	}
+
+	return fm.transitionLocked(fileInfo, to, message)
+}
+
+// transitionLocked moves the job to the next state if the lifecycle allows it.
+// Callers must hold fm.mu.
+func (fm *FileManager) transitionLocked(fileInfo *FileInfo, to JobStatus, message string) error {
+	if !fileInfo.Status.CanTransitionTo(to) {
+		return &TransitionError{From: fileInfo.Status, To: to}
+	}
+
+	from := fileInfo.Status
+	fileInfo.Status = to
+	fileInfo.Message = message
+	fileInfo.UpdatedAt = time.Now()
+	if to == StatusQueued {
+		fileInfo.ClientID = ""
+		fileInfo.Progress = nil
+	}
+
+	fm.persistLocked(fileInfo)
+	fm.notifyLocked(fileInfo)
+
+	if to.IsTerminal() && !from.IsTerminal() && fm.webhooks != nil {
+		fm.webhooks.jobFinished(fileInfo)
+	}
+
+	return nil
+}
+
+func (fm *FileManager) JobDispatched(fileID, clientID string) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fileInfo, exists := fm.files[fileID]
+	if !exists {
+		return errFileNotFound
+	}
+
+	if !fileInfo.Status.CanTransitionTo(StatusDispatched) {
+		return &TransitionError{From: fileInfo.Status, To: StatusDispatched}
+	}
+	fileInfo.ClientID = clientID
+
+	return fm.transitionLocked(fileInfo, StatusDispatched, "")
+}
+
+func (fm *FileManager) JobRequeued(fileID, reason string) error {
+	return fm.transition(fileID, StatusQueued, reason)
+}
+
+func (fm *FileManager) JobFailed(fileID, message string) error {
+	return fm.transition(fileID, StatusFailed, message)
+}
+
+// JobProgress records the phase reported by the client and moves the job to
+// the matching state. Events that arrive after the job has moved on only
+// update the progress.
+func (fm *FileManager) JobProgress(fileID string, progress JobProgress) error {
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	fileInfo, exists := fm.files[fileID]
+	if !exists {
+		return errFileNotFound
+	}
+	if !fileInfo.Status.IsActive() {
+		return &TransitionError{From: fileInfo.Status, To: fileInfo.Status}
+	}
+
+	fileInfo.Progress = &progress
+
+	var next JobStatus
+	switch progress.Phase {
+	case "downloading":
+		next = StatusDownloading
+	case "signing", "timestamping":
+		next = StatusSigning
+	}
+	if next != "" && next != fileInfo.Status && fileInfo.Status.CanTransitionTo(next) {
+		return fm.transitionLocked(fileInfo, next, "")
+	}
+
+	fileInfo.UpdatedAt = progress.UpdatedAt
+	fm.persistLocked(fileInfo)
+	fm.notifyLocked(fileInfo)
+
+	return nil
+}
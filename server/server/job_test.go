@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestFileManager(t *testing.T) *FileManager {
+	t.Helper()
+	return &FileManager{
+		files:       make(map[string]*FileInfo),
+		watchers:    make(map[string][]*statusWatcher),
+		urls:        newURLSigner(),
+		uploadDir:   t.TempDir(),
+		downloadDir: t.TempDir(),
+		sharedDir:   t.TempDir(),
+	}
+}
+
+var allStatuses = []JobStatus{
+	StatusQueued, StatusDispatched, StatusDownloading, StatusSigning, StatusUploaded,
+	StatusReady, StatusFailed, StatusCancelled, StatusExpired,
+}
+
+func TestJobTransitions(t *testing.T) {
+	allowed := map[JobStatus][]JobStatus{
+		StatusQueued:      {StatusDispatched, StatusFailed, StatusCancelled, StatusExpired},
+		StatusDispatched:  {StatusQueued, StatusDownloading, StatusUploaded, StatusFailed, StatusCancelled, StatusExpired},
+		StatusDownloading: {StatusQueued, StatusSigning, StatusUploaded, StatusFailed, StatusCancelled, StatusExpired},
+		StatusSigning:     {StatusQueued, StatusUploaded, StatusFailed, StatusCancelled, StatusExpired},
+		StatusUploaded:    {StatusReady, StatusFailed},
+		StatusReady:       {StatusExpired},
+		StatusFailed:      {StatusExpired},
+		StatusCancelled:   {StatusExpired},
+		StatusExpired:     {},
+	}
+
+	for _, from := range allStatuses {
+		want := map[JobStatus]bool{}
+		for _, to := range allowed[from] {
+			want[to] = true
+		}
+		for _, to := range allStatuses {
+			if got := from.CanTransitionTo(to); got != want[to] {
+				t.Errorf("%s.CanTransitionTo(%s) = %v, want %v", from, to, got, want[to])
+			}
+		}
+	}
+}
+
+func TestJobStatusClasses(t *testing.T) {
+	tests := []struct {
+		status   JobStatus
+		terminal bool
+		active   bool
+	}{
+		{StatusQueued, false, false},
+		{StatusDispatched, false, true},
+		{StatusDownloading, false, true},
+		{StatusSigning, false, true},
+		{StatusUploaded, false, false},
+		{StatusReady, true, false},
+		{StatusFailed, true, false},
+		{StatusCancelled, true, false},
+		{StatusExpired, true, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.status.IsTerminal(); got != tt.terminal {
+			t.Errorf("%s.IsTerminal() = %v, want %v", tt.status, got, tt.terminal)
+		}
+		if got := tt.status.IsActive(); got != tt.active {
+			t.Errorf("%s.IsActive() = %v, want %v", tt.status, got, tt.active)
+		}
+	}
+}
+
+func TestFileManagerRejectsInvalidTransitions(t *testing.T) {
+	fm := newTestFileManager(t)
+	fm.files["job"] = &FileInfo{ID: "job", Status: StatusQueued}
+
+	// A signed upload is not accepted for a job no client holds
+	err := fm.transition("job", StatusUploaded, "")
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) || transitionErr.From != StatusQueued || transitionErr.To != StatusUploaded {
+		t.Fatalf("transition() = %v, want queued to uploaded rejected", err)
+	}
+	if status := fm.files["job"].Status; status != StatusQueued {
+		t.Fatalf("status = %s after rejected transition, want %s", status, StatusQueued)
+	}
+
+	if err := fm.JobDispatched("job", "client"); err != nil {
+		t.Fatal(err)
+	}
+	if fm.files["job"].ClientID != "client" {
+		t.Errorf("ClientID = %q, want client", fm.files["job"].ClientID)
+	}
+	if err := fm.JobRequeued("job", "lease expired"); err != nil {
+		t.Fatal(err)
+	}
+	if info := fm.files["job"]; info.ClientID != "" || info.Message != "lease expired" {
+		t.Errorf("requeued job = %+v, want no client and the reason", info)
+	}
+
+	if err := fm.transition("job", StatusCancelled, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Terminal jobs stay terminal, whatever a late client reports
+	for _, to := range []JobStatus{StatusQueued, StatusDispatched, StatusUploaded, StatusReady} {
+		if err := fm.transition("job", to, ""); !errors.As(err, &transitionErr) {
+			t.Errorf("transition(cancelled, %s) = %v, want TransitionError", to, err)
+		}
+	}
+
+	if err := fm.transition("missing", StatusFailed, ""); err == nil {
+		t.Error("transition() of unknown job succeeded")
+	}
+}
@@ -0,0 +1,13 @@
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLogger("stdout")
+	os.Exit(m.Run())
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"time"
+
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+const retentionSweepInterval = time.Minute
+
+// expireFinishedJobs removes the jobs that finished more than fm.retention
+// ago, as if they were finished through the API, until done is closed.
+func (fm *FileManager) expireFinishedJobs(done <-chan struct{}) {
+	if fm.retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			for _, fileID := range fm.jobsFinishedBefore(now.Add(-fm.retention)) {
+				if err := fm.transition(fileID, StatusExpired, "retention period over"); err != nil {
+					continue
+				}
+				utils.Logger.Info("Removing job %s after the retention period", fileID)
+				fm.cleanupFile(fileID)
+			}
+		}
+	}
+}
+
+func (fm *FileManager) jobsFinishedBefore(cutoff time.Time) []string {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	var expired []string
+	for id, fileInfo := range fm.files {
+		if fileInfo.Status.IsTerminal() && fileInfo.Status != StatusExpired && fileInfo.UpdatedAt.Before(cutoff) {
+			expired = append(expired, id)
+		}
+	}
+	return expired
+}
@@ -1,13 +1,16 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 
+	"github.com/YHVCorp/signer-service/server/config"
 	"github.com/gin-gonic/gin"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type Server struct {
@@ -15,64 +18,127 @@ type Server struct {
 	httpServer   *http.Server
 	signerServer *SignerServer
 	fileManager  *FileManager
+	tlsConfig    *tls.Config
+	grpcAddress  string
+	httpAddress  string
+	tlsSettings  config.TLSConfig
+	done         chan struct{}
 }
 
-func NewServer() *Server {
+// NewServer returns a server set up from cnf, as returned by
+// config.LoadConfig.
+func NewServer(cnf *config.Config) *Server {
+	signerServer := NewSignerServer()
+	if cnf.Queue.LeaseTimeout > 0 {
+		signerServer.leaseTimeout = cnf.Queue.LeaseTimeout
+	}
+	if cnf.Queue.MaxAttempts > 0 {
+		signerServer.maxAttempts = cnf.Queue.MaxAttempts
+	}
+
+	fileManager := NewFileManager(cnf.Storage)
+	fileManager.maxUploadSize = cnf.Limits.MaxUploadMB << 20
+	fileManager.retention = cnf.Limits.Retention
+	fileManager.maxPending = cnf.Queue.MaxPending
+	// Validated by config.LoadConfig
+	fileManager.webhooks.allowed, _ = cnf.Webhooks.Networks()
+	signerServer.SetJobListener(fileManager)
+	signerServer.setFileTransfer(fileManager)
+	fileManager.urls = signerServer.urls
+
 	return &Server{
-		signerServer: NewSignerServer(),
-		fileManager:  NewFileManager(),
+		signerServer: signerServer,
+		fileManager:  fileManager,
+		grpcAddress:  cnf.Listen.GRPC,
+		httpAddress:  cnf.Listen.HTTP,
+		tlsSettings:  cnf.TLS,
+		done:         make(chan struct{}),
 	}
 }
 
-func (s *Server) Start(grpcPort, httpPort string) error {
+func (s *Server) Start() error {
+	var err error
+	s.tlsConfig, err = loadTLSConfig(s.tlsSettings)
+	if err != nil {
+		return fmt.Errorf("invalid TLS configuration: %v", err)
+	}
+
 	if err := s.fileManager.Setup(); err != nil {
 		return fmt.Errorf("failed to setup file manager: %v", err)
 	}
 
+	s.signerServer.Start()
+	s.fileManager.ResumeJobs(s.signerServer)
+	go s.fileManager.expireFinishedJobs(s.done)
+
 	errChan := make(chan error, 2)
 
 	go func() {
-		errChan <- s.startGRPCServer(grpcPort)
+		errChan <- s.startGRPCServer(s.grpcAddress)
 	}()
 
 	go func() {
-		errChan <- s.startHTTPServer(httpPort)
+		errChan <- s.startHTTPServer(s.httpAddress)
 	}()
 
 	return <-errChan
 }
 
-func (s *Server) startGRPCServer(port string) error {
-	lis, err := net.Listen("tcp", ":"+port)
+func (s *Server) startGRPCServer(address string) error {
+	lis, err := net.Listen("tcp", address)
 	if err != nil {
-		return fmt.Errorf("failed to listen on port %s: %v", port, err)
+		return fmt.Errorf("failed to listen on %s: %v", address, err)
+	}
+
+	var opts []grpc.ServerOption
+	if s.tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.tlsConfig)))
 	}
 
-	s.grpcServer = grpc.NewServer()
+	s.grpcServer = grpc.NewServer(opts...)
 	s.signerServer.RegisterGRPC(s.grpcServer)
 
-	log.Printf("gRPC server starting on port %s", port)
+	log.Printf("gRPC server starting on %s%s", address, s.tlsDescription())
 	return s.grpcServer.Serve(lis)
 }
 
-func (s *Server) startHTTPServer(port string) error {
+func (s *Server) startHTTPServer(address string) error {
 	router := gin.Default()
 	s.fileManager.SetupHTTPRoutes(router, s.signerServer)
 
 	s.httpServer = &http.Server{
-		Addr:    ":" + port,
-		Handler: router,
+		Addr:      address,
+		Handler:   router,
+		TLSConfig: s.tlsConfig,
 	}
 
-	log.Printf("HTTP server starting on port %s", port)
+	log.Printf("HTTP server starting on %s%s", address, s.tlsDescription())
+	if s.tlsConfig != nil {
+		// The certificate is already loaded in TLSConfig
+		return s.httpServer.ListenAndServeTLS("", "")
+	}
 	return s.httpServer.ListenAndServe()
 }
 
+func (s *Server) tlsDescription() string {
+	switch {
+	case s.tlsConfig == nil:
+		return " without TLS"
+	case s.tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert:
+		return " with mutual TLS"
+	default:
+		return " with TLS"
+	}
+}
+
 func (s *Server) Stop() {
+	close(s.done)
+	s.signerServer.Stop()
 	if s.grpcServer != nil {
 		s.grpcServer.GracefulStop()
 	}
 	if s.httpServer != nil {
 		s.httpServer.Close()
 	}
+	s.fileManager.Close()
 }
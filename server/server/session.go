@@ -0,0 +1,131 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/YHVCorp/signer-service/proto"
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+// JobProgress is the last phase reported by the client working on a job.
+type JobProgress struct {
+	Phase      string    `json:"phase"`
+	BytesDone  int64     `json:"bytes_done,omitempty"`
+	BytesTotal int64     `json:"bytes_total,omitempty"`
+	Message    string    `json:"message,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// JobSession is the bidirectional successor of StreamSignRequests. The server
+// sends sign requests and cancellations, and the client answers with an
+// acknowledgement, the phases it goes through and the final result. Every
+// event from the client renews the lease of the job it refers to.
+func (s *SignerServer) JobSession(stream proto.SignerService_JobSessionServer) error {
+	if err := s.validateToken(stream.Context()); err != nil {
+		utils.Logger.ErrorF("authentication failed: %v", err)
+		return fmt.Errorf("authentication failed: %v", err)
+	}
+
+	clientID := getClientID(stream.Context())
+	session := newClientSession(clientID, getClientProfiles(stream.Context()))
+	session.capacity = getClientCapacity(stream.Context())
+	session.interactive = true
+
+	s.registerClient(session)
+	defer s.unregisterClient(session)
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			s.handleClientEvent(clientID, event)
+		}
+	}()
+
+	sendErr := make(chan error, 1)
+	go func() {
+		sendErr <- s.serveSession(stream.Context(), session,
+			func(req *proto.SignRequest) error {
+				return stream.Send(&proto.ServerCommand{
+					Type:        proto.CommandType_COMMAND_TYPE_SIGN,
+					SignRequest: req,
+				})
+			},
+			func(requestID string) error {
+				return stream.Send(&proto.ServerCommand{
+					Type:      proto.CommandType_COMMAND_TYPE_CANCEL,
+					RequestId: requestID,
+				})
+			})
+	}()
+
+	select {
+	case err := <-recvErr:
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	case err := <-sendErr:
+		return err
+	}
+}
+
+func (s *SignerServer) handleClientEvent(clientID string, event *proto.ClientEvent) {
+	switch event.Phase {
+	case proto.JobPhase_JOB_PHASE_COMPLETED:
+		s.handleSignResult(clientID, event.RequestId, true, event.Message)
+		return
+	case proto.JobPhase_JOB_PHASE_FAILED:
+		s.handleSignResult(clientID, event.RequestId, false, event.Message)
+		return
+	}
+
+	s.mu.Lock()
+	job, exists := s.jobs[event.RequestId]
+	if !exists || job.session == nil || job.session.id != clientID || !job.leased() {
+		s.mu.Unlock()
+		utils.Logger.ErrorF("Ignoring %s event for request %s from client %s: job is not leased to it",
+			event.Phase, event.RequestId, clientID)
+		return
+	}
+	job.leaseExpires = time.Now().Add(s.leaseTimeout)
+	listener := s.listener
+	s.mu.Unlock()
+
+	if listener == nil {
+		return
+	}
+
+	progress := JobProgress{
+		Phase:      phaseName(event.Phase),
+		BytesDone:  event.BytesDone,
+		BytesTotal: event.BytesTotal,
+		Message:    event.Message,
+		UpdatedAt:  time.Now(),
+	}
+	if err := listener.JobProgress(event.RequestId, progress); err != nil {
+		utils.Logger.ErrorF("Failed to record progress of request %s: %v", event.RequestId, err)
+	}
+}
+
+// takeCancellations returns the cancellations waiting to be sent to the
+// session.
+func (s *SignerServer) takeCancellations(session *clientSession) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cancels := session.cancels
+	session.cancels = nil
+	return cancels
+}
+
+func phaseName(phase proto.JobPhase) string {
+	return strings.ToLower(strings.TrimPrefix(phase.String(), "JOB_PHASE_"))
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	urlActionDownload = "download"
+	urlActionUpload   = "upload"
+)
+
+var (
+	errURLSignature = errors.New("invalid or missing URL signature")
+	errURLExpired   = errors.New("signed URL expired")
+	errNotJobOwner  = errors.New("job is not assigned to this client")
+)
+
+// urlSigner signs the transfer URLs handed to clients in a SignRequest. A
+// signature is bound to the action, the job, the client the job is leased to
+// and the expiry, so a URL cannot be reused for another job, by another
+// client or once the lease is over. The key is generated at startup, which
+// invalidates outstanding URLs on restart together with the leases.
+type urlSigner struct {
+	key []byte
+}
+
+func newURLSigner() *urlSigner {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &urlSigner{key: key}
+}
+
+// signPath appends the client, expiry and signature query parameters to
+// path.
+func (us *urlSigner) signPath(path, action, fileID, clientID string, expires time.Time) string {
+	query := url.Values{}
+	query.Set("client", clientID)
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", us.signature(action, fileID, clientID, expires.Unix()))
+	return fmt.Sprintf("%s?%s", path, query.Encode())
+}
+
+// verify checks the signature of a request for the job and returns the
+// client the URL was issued to.
+func (us *urlSigner) verify(action, fileID string, query url.Values) (string, error) {
+	clientID := query.Get("client")
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil || len(sig) == 0 {
+		return "", errURLSignature
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return "", errURLSignature
+	}
+
+	expected, _ := hex.DecodeString(us.signature(action, fileID, clientID, expires))
+	if !hmac.Equal(sig, expected) {
+		return "", errURLSignature
+	}
+	if time.Now().Unix() > expires {
+		return "", errURLExpired
+	}
+
+	return clientID, nil
+}
+
+func (us *urlSigner) signature(action, fileID, clientID string, expires int64) string {
+	mac := hmac.New(sha256.New, us.key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%d", action, fileID, clientID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignedURL checks the signature of a transfer request and that the job
+// is still assigned to the client the URL was issued to.
+func (fm *FileManager) verifySignedURL(c *gin.Context, action, fileID string) error {
+	clientID, err := fm.urls.verify(action, fileID, c.Request.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	fileInfo, exists := fm.files[fileID]
+	if !exists {
+		return errFileNotFound
+	}
+	if fileInfo.ClientID != clientID {
+		return errNotJobOwner
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSignedURLVerification(t *testing.T) {
+	us := newURLSigner()
+	expires := time.Now().Add(time.Minute)
+
+	signed := us.signPath("/unsigned/job", urlActionDownload, "job", "client", expires)
+	parsed, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Path != "/unsigned/job" {
+		t.Fatalf("signed path = %s", parsed.Path)
+	}
+	valid := parsed.Query()
+
+	tamper := func(key, value string) url.Values {
+		query := url.Values{}
+		for k, v := range valid {
+			query[k] = append([]string(nil), v...)
+		}
+		query.Set(key, value)
+		return query
+	}
+	flipped := []byte(valid.Get("sig"))
+	if flipped[0] == '0' {
+		flipped[0] = '1'
+	} else {
+		flipped[0] = '0'
+	}
+
+	tests := []struct {
+		name    string
+		action  string
+		fileID  string
+		query   url.Values
+		wantErr error
+	}{
+		{"valid", urlActionDownload, "job", valid, nil},
+		{"other action", urlActionUpload, "job", valid, errURLSignature},
+		{"other job", urlActionDownload, "other", valid, errURLSignature},
+		{"other client", urlActionDownload, "job", tamper("client", "attacker"), errURLSignature},
+		{"extended expiry", urlActionDownload, "job", tamper("expires", strconv.FormatInt(expires.Add(time.Hour).Unix(), 10)), errURLSignature},
+		{"tampered signature", urlActionDownload, "job", tamper("sig", string(flipped)), errURLSignature},
+		{"malformed signature", urlActionDownload, "job", tamper("sig", "not hex"), errURLSignature},
+		{"missing signature", urlActionDownload, "job", tamper("sig", ""), errURLSignature},
+		{"malformed expiry", urlActionDownload, "job", tamper("expires", "soon"), errURLSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientID, err := us.verify(tt.action, tt.fileID, tt.query)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verify() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && clientID != "client" {
+				t.Errorf("verify() client = %s, want client", clientID)
+			}
+		})
+	}
+
+	// A URL signed by another server instance, as after a restart
+	if _, err := newURLSigner().verify(urlActionDownload, "job", valid); !errors.Is(err, errURLSignature) {
+		t.Errorf("verify() with another key = %v, want %v", err, errURLSignature)
+	}
+}
+
+func TestSignedURLExpired(t *testing.T) {
+	us := newURLSigner()
+
+	signed := us.signPath("/unsigned/job", urlActionDownload, "job", "client", time.Now().Add(-time.Second))
+	parsed, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := us.verify(urlActionDownload, "job", parsed.Query()); !errors.Is(err, errURLExpired) {
+		t.Fatalf("verify() = %v, want %v", err, errURLExpired)
+	}
+}
+
+func TestVerifySignedURLChecksJobOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	fm := newTestFileManager(t)
+	fm.files["job"] = &FileInfo{ID: "job", Status: StatusDispatched, ClientID: "client"}
+
+	request := func(signed string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, signed, nil)
+		return c
+	}
+	expires := time.Now().Add(time.Minute)
+
+	valid := fm.urls.signPath("/unsigned/job", urlActionDownload, "job", "client", expires)
+	if err := fm.verifySignedURL(request(valid), urlActionDownload, "job"); err != nil {
+		t.Fatalf("verifySignedURL() = %v, want nil", err)
+	}
+
+	// The job has been requeued and leased to another client since the URL
+	// was issued
+	fm.files["job"].ClientID = "other"
+	if err := fm.verifySignedURL(request(valid), urlActionDownload, "job"); !errors.Is(err, errNotJobOwner) {
+		t.Fatalf("verifySignedURL() = %v, want %v", err, errNotJobOwner)
+	}
+
+	missing := fm.urls.signPath("/unsigned/gone", urlActionDownload, "gone", "client", expires)
+	if err := fm.verifySignedURL(request(missing), urlActionDownload, "gone"); !errors.Is(err, errFileNotFound) {
+		t.Fatalf("verifySignedURL() = %v, want %v", err, errFileNotFound)
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/YHVCorp/signer-service/server/utils"
+	bolt "go.etcd.io/bbolt"
+)
+
+// JobStore persists job metadata so jobs survive a server restart.
+type JobStore interface {
+	Load() ([]*FileInfo, error)
+	Save(fileInfo *FileInfo) error
+	Delete(fileID string) error
+	Close() error
+}
+
+var jobsBucket = []byte("jobs")
+
+type BoltJobStore struct {
+	db *bolt.DB
+}
+
+func NewBoltJobStore(path string) (*BoltJobStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening job store %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error initializing job store: %v", err)
+	}
+
+	return &BoltJobStore{db: db}, nil
+}
+
+func (bs *BoltJobStore) Load() ([]*FileInfo, error) {
+	var files []*FileInfo
+
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			var fileInfo FileInfo
+			if err := json.Unmarshal(v, &fileInfo); err != nil {
+				return fmt.Errorf("error decoding job %s: %v", k, err)
+			}
+			files = append(files, &fileInfo)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func (bs *BoltJobStore) Save(fileInfo *FileInfo) error {
+	data, err := json.Marshal(fileInfo)
+	if err != nil {
+		return fmt.Errorf("error encoding job %s: %v", fileInfo.ID, err)
+	}
+
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(fileInfo.ID), data)
+	})
+}
+
+func (bs *BoltJobStore) Delete(fileID string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Delete([]byte(fileID))
+	})
+}
+
+func (bs *BoltJobStore) Close() error {
+	return bs.db.Close()
+}
+
+// persistLocked writes the job to the store. Callers must hold fm.mu.
+func (fm *FileManager) persistLocked(fileInfo *FileInfo) {
+	if fm.store == nil {
+		return
+	}
+	if err := fm.store.Save(fileInfo); err != nil {
+		utils.Logger.ErrorF("Failed to persist job %s: %v", fileInfo.ID, err)
+	}
+}
+
+// restoreJobs reloads the jobs saved before the last shutdown and reconciles
+// them with the files that are actually on disk. Jobs that were in progress go
+// back to the queue, and files that no job refers to are removed.
+func (fm *FileManager) restoreJobs() error {
+	files, err := fm.store.Load()
+	if err != nil {
+		return fmt.Errorf("error loading jobs: %v", err)
+	}
+
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
+
+	for _, fileInfo := range files {
+		unsignedPath := filepath.Join(fm.uploadDir, fileInfo.ID)
+		signedPath := filepath.Join(fm.downloadDir, fileInfo.ID)
+
+		if fileInfo.Status == StatusExpired {
+			os.Remove(unsignedPath)
+			os.Remove(signedPath)
+			if err := fm.store.Delete(fileInfo.ID); err != nil {
+				utils.Logger.ErrorF("Failed to delete job %s from store: %v", fileInfo.ID, err)
+			}
+			continue
+		}
+
+		fm.files[fileInfo.ID] = fileInfo
+
+		switch {
+		case fileInfo.Status == StatusQueued || fileInfo.Status.IsActive():
+			if !utils.FileExists(unsignedPath) {
+				fm.restoreStatusLocked(fileInfo, StatusFailed, "unsigned file missing after server restart")
+			} else if fileInfo.Status != StatusQueued {
+				fm.restoreStatusLocked(fileInfo, StatusQueued, "requeued after server restart")
+			}
+		case fileInfo.Status == StatusUploaded:
+			fm.restoreStatusLocked(fileInfo, StatusFailed, "signed upload interrupted by server restart")
+		case fileInfo.Status == StatusReady:
+			if !utils.FileExists(signedPath) {
+				fm.restoreStatusLocked(fileInfo, StatusFailed, "signed file missing after server restart")
+			}
+		}
+	}
+
+	fm.removeOrphanedFiles(fm.uploadDir)
+	fm.removeOrphanedFiles(fm.downloadDir)
+
+	utils.Logger.Info("Restored %d jobs from %s", len(fm.files), fm.storePath)
+
+	return nil
+}
+
+// restoreStatusLocked sets the status of a restored job to match the files on
+// disk. It bypasses the transition table, which has no move from ready to
+// failed, and notifies neither watchers nor webhooks: nobody is subscribed yet
+// and the job did not change while the server was running. Callers must hold
+// fm.mu.
+func (fm *FileManager) restoreStatusLocked(fileInfo *FileInfo, to JobStatus, message string) {
+	utils.Logger.Info("Restored job %s moved from %s to %s: %s", fileInfo.ID, fileInfo.Status, to, message)
+
+	fileInfo.Status = to
+	fileInfo.Message = message
+	fileInfo.UpdatedAt = time.Now()
+	fileInfo.ClientID = ""
+	fileInfo.Progress = nil
+
+	fm.persistLocked(fileInfo)
+}
+
+// removeOrphanedFiles deletes files with no matching job. Callers must hold
+// fm.mu.
+func (fm *FileManager) removeOrphanedFiles(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		utils.Logger.ErrorF("Failed to list %s: %v", dir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, exists := fm.files[entry.Name()]; exists {
+			continue
+		}
+		utils.Logger.Info("Removing orphaned file %s", filepath.Join(dir, entry.Name()))
+		os.Remove(filepath.Join(dir, entry.Name()))
+	}
+}
+
+// ResumeJobs sends the queued jobs restored from the store to the signer
+// server, oldest first.
+func (fm *FileManager) ResumeJobs(signerServer *SignerServer) {
+	fm.mu.RLock()
+	var queued []*FileInfo
+	for _, fileInfo := range fm.files {
+		if fileInfo.Status == StatusQueued {
+			queued = append(queued, fileInfo)
+		}
+	}
+	fm.mu.RUnlock()
+
+	sort.Slice(queued, func(i, j int) bool { return queued[i].CreatedAt.Before(queued[j].CreatedAt) })
+
+	for _, fileInfo := range queued {
+		fm.enqueue(signerServer, fileInfo)
+	}
+}
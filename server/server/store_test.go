@@ -0,0 +1,68 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreJobsReconcilesWithFiles(t *testing.T) {
+	fm := newTestFileManager(t)
+	store, err := NewBoltJobStore(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	fm.store = store
+
+	jobs := []struct {
+		info     *FileInfo
+		unsigned bool
+		signed   bool
+		want     JobStatus
+	}{
+		{&FileInfo{ID: "signing", Status: StatusSigning, ClientID: "client"}, true, false, StatusQueued},
+		{&FileInfo{ID: "lost-upload", Status: StatusDispatched}, false, false, StatusFailed},
+		{&FileInfo{ID: "uploaded", Status: StatusUploaded}, true, false, StatusFailed},
+		{&FileInfo{ID: "ready", Status: StatusReady}, true, true, StatusReady},
+		// The transition table has no move from ready to failed
+		{&FileInfo{ID: "lost-signed", Status: StatusReady}, true, false, StatusFailed},
+	}
+	for _, job := range jobs {
+		if err := store.Save(job.info); err != nil {
+			t.Fatal(err)
+		}
+		if job.unsigned {
+			os.WriteFile(filepath.Join(fm.uploadDir, job.info.ID), []byte("unsigned"), 0644)
+		}
+		if job.signed {
+			os.WriteFile(filepath.Join(fm.downloadDir, job.info.ID), []byte("signed"), 0644)
+		}
+	}
+
+	if err := fm.restoreJobs(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	persisted := map[string]JobStatus{}
+	for _, info := range saved {
+		persisted[info.ID] = info.Status
+	}
+
+	for _, job := range jobs {
+		info := fm.files[job.info.ID]
+		if info.Status != job.want {
+			t.Errorf("%s: status = %s, want %s", job.info.ID, info.Status, job.want)
+		}
+		if persisted[job.info.ID] != job.want {
+			t.Errorf("%s: persisted status = %s, want %s", job.info.ID, persisted[job.info.ID], job.want)
+		}
+		if info.ClientID != "" {
+			t.Errorf("%s: still held by client %s", job.info.ID, info.ClientID)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/YHVCorp/signer-service/server/config"
+)
+
+// loadTLSConfig returns the TLS settings shared by the gRPC and HTTP
+// listeners, nil when no server certificate is configured. With a client CA
+// every connection must present a certificate issued by it, on top of the
+// bearer token. The settings are expected to be validated already.
+func loadTLSConfig(cnf config.TLSConfig) (*tls.Config, error) {
+	if cnf.Cert == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cnf.Cert, cnf.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error loading server certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if cnf.ClientCA != "" {
+		pool, err := loadCertPool(cnf.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client CA: %v", err)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/YHVCorp/signer-service/proto"
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+const transferChunkSize = 256 * 1024
+
+// fileTransfer gives the transfer RPCs access to the files of a job.
+type fileTransfer interface {
+	beginUnsignedDownload(fileID string) (string, error)
+	finishUnsignedDownload(fileID string)
+	storeSignedFile(fileID string, upload signedUpload, write func(w io.Writer) error) (bool, error)
+}
+
+func (s *SignerServer) setFileTransfer(files fileTransfer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = files
+}
+
+// DownloadFile streams the unsigned file of a job to the client holding its
+// lease. The first chunk carries the total size and SHA-256 of the file, and
+// every chunk carries the CRC-32 of its data.
+func (s *SignerServer) DownloadFile(req *proto.FileRequest, stream proto.SignerService_DownloadFileServer) error {
+	if err := s.validateToken(stream.Context()); err != nil {
+		return fmt.Errorf("authentication failed: %v", err)
+	}
+
+	clientID := getClientID(stream.Context())
+	if !s.ownsJob(req.RequestId, clientID) {
+		return fmt.Errorf("request %s is not leased to client %s", req.RequestId, clientID)
+	}
+
+	filePath, err := s.files.beginUnsignedDownload(req.RequestId)
+	if err != nil {
+		return err
+	}
+
+	size, digest, err := fileDigest(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	buf := make([]byte, transferChunkSize)
+	var offset int64
+	for {
+		n, err := file.Read(buf)
+		if n > 0 || offset == 0 {
+			chunk := &proto.FileChunk{
+				RequestId: req.RequestId,
+				Offset:    offset,
+				Data:      buf[:n],
+				Crc32:     crc32.ChecksumIEEE(buf[:n]),
+			}
+			if offset == 0 {
+				chunk.TotalSize = size
+				chunk.Sha256 = digest
+			}
+			if sendErr := stream.Send(chunk); sendErr != nil {
+				return sendErr
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file: %v", err)
+		}
+	}
+
+	s.files.finishUnsignedDownload(req.RequestId)
+	utils.Logger.Info("Sent %d bytes of request %s to client %s", offset, req.RequestId, clientID)
+
+	return nil
+}
+
+// UploadSignedFile receives the signed file of a job in chunks, verifying the
+// CRC-32 of every chunk and the SHA-256 of the whole file announced in the
+// first chunk before the job is marked ready.
+func (s *SignerServer) UploadSignedFile(stream proto.SignerService_UploadSignedFileServer) error {
+	if err := s.validateToken(stream.Context()); err != nil {
+		return fmt.Errorf("authentication failed: %v", err)
+	}
+
+	clientID := getClientID(stream.Context())
+
+	first, err := stream.Recv()
+	if err != nil {
+		return fmt.Errorf("failed to receive first chunk: %v", err)
+	}
+
+	requestID := first.RequestId
+	if !s.ownsJob(requestID, clientID) {
+		return fmt.Errorf("request %s is not leased to client %s", requestID, clientID)
+	}
+	if first.Sha256 == "" {
+		return fmt.Errorf("missing sha256 in first chunk of request %s", requestID)
+	}
+
+	upload := signedUpload{
+		Sha256:       first.Sha256,
+		TimestampURL: first.TimestampUrl,
+	}
+
+	var received int64
+	var digest string
+	claimed, err := s.files.storeSignedFile(requestID, upload, func(w io.Writer) error {
+		hasher := sha256.New()
+		out := io.MultiWriter(w, hasher)
+
+		for chunk := first; ; {
+			if chunk.RequestId != requestID {
+				return fmt.Errorf("chunk for request %s received in upload of %s", chunk.RequestId, requestID)
+			}
+			if chunk.Offset != received {
+				return fmt.Errorf("chunk at offset %d, expected %d", chunk.Offset, received)
+			}
+			if crc32.ChecksumIEEE(chunk.Data) != chunk.Crc32 {
+				return fmt.Errorf("checksum mismatch in chunk at offset %d", chunk.Offset)
+			}
+			if _, err := out.Write(chunk.Data); err != nil {
+				return err
+			}
+			received += int64(len(chunk.Data))
+
+			var err error
+			chunk, err = stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if received != first.TotalSize {
+			return fmt.Errorf("received %d bytes, expected %d", received, first.TotalSize)
+		}
+		digest = hex.EncodeToString(hasher.Sum(nil))
+
+		return nil
+	})
+	if claimed {
+		s.CompleteJob(requestID)
+	}
+	if err != nil {
+		utils.Logger.ErrorF("Failed to receive signed file for request %s from client %s: %v", requestID, clientID, err)
+		return err
+	}
+
+	utils.Logger.Info("Received %d bytes of signed request %s from client %s", received, requestID, clientID)
+
+	return stream.SendAndClose(&proto.TransferResult{
+		RequestId: requestID,
+		Size:      received,
+		Sha256:    digest,
+	})
+}
+
+func (s *SignerServer) ownsJob(requestID, clientID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, exists := s.jobs[requestID]
+	return exists && job.session != nil && job.session.id == clientID
+}
+
+func fileDigest(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, file)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/YHVCorp/signer-service/authenticode"
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+// VerificationError reports a signed file that does not pass the Authenticode
+// checks. The job is failed instead of being handed to CI.
+type VerificationError struct {
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("signature verification failed: %s", e.Reason)
+}
+
+// errNotVerifiable is returned for uploads the server could not verify once
+// signed, when require_thumbprint asks for every signature to be checked.
+var errNotVerifiable = errors.New("only PE files can be signed, as other file types cannot be verified")
+
+// isPEFile reports whether the file at path is a PE image, whose signature is
+// verified once it is signed. Other formats, such as MSI, CAB, MSIX or
+// scripts, are signed without verification.
+func isPEFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	_, err = authenticode.Parse(file, info.Size())
+	if errors.Is(err, authenticode.ErrNotPE) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("invalid PE file: %v", err)
+	}
+	return true, nil
+}
+
+// verifySignedFile checks that the signed PE file carries a valid, timestamped
+// Authenticode signature, made by the certificate with the expected thumbprint
+// when the job has one, and that it is the original file with nothing changed
+// outside the signature.
+func verifySignedFile(unsignedPath, signedPath, thumbprint string) error {
+	unsignedFile, err := os.Open(unsignedPath)
+	if err != nil {
+		return err
+	}
+	defer unsignedFile.Close()
+
+	unsignedInfo, err := unsignedFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	original, err := authenticode.Parse(unsignedFile, unsignedInfo.Size())
+	if errors.Is(err, authenticode.ErrNotPE) {
+		return &VerificationError{Reason: errNotVerifiable.Error()}
+	}
+	if err != nil {
+		return &VerificationError{Reason: fmt.Sprintf("original file: %v", err)}
+	}
+
+	signedFile, err := os.Open(signedPath)
+	if err != nil {
+		return err
+	}
+	defer signedFile.Close()
+
+	signedInfo, err := signedFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	signed, err := authenticode.Parse(signedFile, signedInfo.Size())
+	if err != nil {
+		return &VerificationError{Reason: err.Error()}
+	}
+
+	sig, err := signed.Signature()
+	if err != nil {
+		return &VerificationError{Reason: err.Error()}
+	}
+
+	if expected := normalizeThumbprint(thumbprint); expected != "" && expected != sig.Thumbprint() {
+		return &VerificationError{Reason: fmt.Sprintf("signed by certificate %s, expected %s", sig.Thumbprint(), expected)}
+	}
+	if sig.Timestamp == nil {
+		return &VerificationError{Reason: "signature has no timestamp"}
+	}
+
+	if err := authenticode.CompareContent(original, signed); err != nil {
+		return &VerificationError{Reason: err.Error()}
+	}
+
+	utils.Logger.Info("Verified signature of %s: signer %s, timestamp %s", signedPath, sig.Thumbprint(), sig.Timestamp.Time)
+
+	return nil
+}
+
+// normalizeThumbprint accepts thumbprints copied from the Windows certificate
+// dialog, which may contain spaces, colons or invisible marks, in any case.
+func normalizeThumbprint(thumbprint string) string {
+	return strings.ToUpper(strings.Map(func(r rune) rune {
+		if strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return r
+		}
+		return -1
+	}, thumbprint))
+}
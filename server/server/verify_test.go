@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The PE fixtures of the shared Authenticode module.
+var fixtureDir = filepath.Join("..", "..", "authenticode", "testdata")
+
+func TestIsPEFile(t *testing.T) {
+	unsigned, err := os.ReadFile(filepath.Join(fixtureDir, "unsigned.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		pe      bool
+		wantErr bool
+	}{
+		{"pe", unsigned, true, false},
+		{"msi", []byte("\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1 compound file"), false, false},
+		{"script", []byte("Write-Host 'hello'\r\n"), false, false},
+		{"truncated pe", unsigned[:0x60], false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			pe, err := isPEFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("isPEFile() error = %v, want error %v", err, tt.wantErr)
+			}
+			if pe != tt.pe {
+				t.Errorf("isPEFile() = %v, want %v", pe, tt.pe)
+			}
+		})
+	}
+}
+
+func TestStoreSignedFileVerifiesOnlyPEFiles(t *testing.T) {
+	unsigned, err := os.ReadFile(filepath.Join(fixtureDir, "unsigned.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := []byte("Write-Host 'hello'\r\n# SIG # Begin signature block\r\n")
+
+	tests := []struct {
+		name       string
+		original   []byte
+		signed     []byte
+		unverified bool
+		want       JobStatus
+	}{
+		// The client returned the PE file without a signature
+		{"unsigned pe", unsigned, unsigned, false, StatusFailed},
+		{"script", script[:20], script, true, StatusReady},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm := newTestFileManager(t)
+			fm.files["job"] = &FileInfo{ID: "job", Status: StatusSigning, Unverified: tt.unverified}
+			if err := os.WriteFile(filepath.Join(fm.uploadDir, "job"), tt.original, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			digest := sha256.Sum256(tt.signed)
+			upload := signedUpload{Sha256: hex.EncodeToString(digest[:])}
+			_, err := fm.storeSignedFile("job", upload, func(w io.Writer) error {
+				_, err := w.Write(tt.signed)
+				return err
+			})
+
+			var verificationErr *VerificationError
+			if tt.want == StatusFailed && !errors.As(err, &verificationErr) {
+				t.Errorf("storeSignedFile() = %v, want VerificationError", err)
+			}
+			if tt.want == StatusReady && err != nil {
+				t.Errorf("storeSignedFile() = %v", err)
+			}
+			if status := fm.files["job"].Status; status != tt.want {
+				t.Errorf("status = %s, want %s", status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/YHVCorp/signer-service/server/config"
+	"github.com/YHVCorp/signer-service/server/utils"
+)
+
+const (
+	webhookEventJobFinished = "job.finished"
+	webhookTimeout          = 10 * time.Second
+	webhookMaxAttempts      = 6
+	webhookInitialBackoff   = 2 * time.Second
+	webhookMaxBackoff       = 2 * time.Minute
+)
+
+// WebhookPayload is the JSON body posted to webhooks when a job finishes.
+type WebhookPayload struct {
+	Event        string    `json:"event"`
+	JobID        string    `json:"job_id"`
+	FileName     string    `json:"file_name"`
+	Profile      string    `json:"profile,omitempty"`
+	Status       JobStatus `json:"status"`
+	Message      string    `json:"message,omitempty"`
+	Sha256       string    `json:"sha256,omitempty"`
+	SignedSha256 string    `json:"signed_sha256,omitempty"`
+	TimestampURL string    `json:"timestamp_url,omitempty"`
+	Unverified   bool      `json:"unverified,omitempty"`
+	DownloadPath string    `json:"download_path,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// WebhookNotifier posts job results to the callback URL of the job and the
+// webhooks of the token it was uploaded with. The body is signed with
+// HMAC-SHA256 keyed by the webhook secret of that token and sent in the
+// X-Signer-Signature header as sha256=<hex>; nothing is sent for a token
+// without a secret. Failed deliveries are retried with exponential backoff;
+// pending retries do not survive a restart.
+type WebhookNotifier struct {
+	client *http.Client
+	// callbackClient only connects to addresses callbacks may be sent to,
+	// whatever the callback host resolves to when the job finishes and
+	// wherever it redirects
+	callbackClient *http.Client
+	// allowed are the networks callbacks may reach although they are
+	// internal
+	allowed []*net.IPNet
+}
+
+func NewWebhookNotifier() *WebhookNotifier {
+	wn := &WebhookNotifier{
+		client: &http.Client{Timeout: webhookTimeout},
+	}
+
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !wn.addressAllowed(ip) {
+				return fmt.Errorf("callback address %s is not allowed", host)
+			}
+			return nil
+		},
+	}
+	wn.callbackClient = &http.Client{
+		Timeout: webhookTimeout,
+		// A proxy would connect to the callback host on the server's behalf
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+
+	return wn
+}
+
+// jobFinished queues the delivery of the final status of a job. Callers must
+// hold fm.mu, so the payload is built before returning and sent in the
+// background.
+func (wn *WebhookNotifier) jobFinished(fileInfo *FileInfo) {
+	payload := WebhookPayload{
+		Event:        webhookEventJobFinished,
+		JobID:        fileInfo.ID,
+		FileName:     fileInfo.FileName,
+		Profile:      fileInfo.Profile,
+		Status:       fileInfo.Status,
+		Message:      fileInfo.Message,
+		Sha256:       fileInfo.Sha256,
+		SignedSha256: fileInfo.SignedSha256,
+		TimestampURL: fileInfo.TimestampURL,
+		Unverified:   fileInfo.Unverified,
+		Timestamp:    time.Now(),
+	}
+	if fileInfo.Status == StatusReady {
+		payload.DownloadPath = fmt.Sprintf("/api/v1/download/%s", fileInfo.ID)
+	}
+
+	go wn.deliver(fileInfo.CallbackURL, fileInfo.TokenName, payload)
+}
+
+func (wn *WebhookNotifier) deliver(callbackURL, tokenName string, payload WebhookPayload) {
+	webhooks, secret, err := config.TokenWebhooks(tokenName)
+	if err != nil {
+		utils.Logger.ErrorF("Failed to send webhooks for job %s: %v", payload.JobID, err)
+		return
+	}
+
+	if callbackURL == "" && len(webhooks) == 0 {
+		return
+	}
+	if secret == "" {
+		utils.Logger.ErrorF("Not sending webhooks for job %s: token %s has no webhook secret", payload.JobID, tokenName)
+		return
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		utils.Logger.ErrorF("Failed to encode webhook payload for job %s: %v", payload.JobID, err)
+		return
+	}
+	signature := signWebhook(secret, body)
+
+	if callbackURL != "" {
+		go wn.deliverWithRetry(wn.callbackClient, callbackURL, payload, body, signature)
+	}
+	for _, webhook := range webhooks {
+		if webhook != callbackURL {
+			go wn.deliverWithRetry(wn.client, webhook, payload, body, signature)
+		}
+	}
+}
+
+func (wn *WebhookNotifier) deliverWithRetry(client *http.Client, target string, payload WebhookPayload, body []byte, signature string) {
+	backoff := webhookInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := wn.post(client, target, payload, body, signature)
+		if err == nil {
+			utils.Logger.Info("Webhook for job %s delivered to %s", payload.JobID, target)
+			return
+		}
+
+		if attempt >= webhookMaxAttempts {
+			utils.Logger.ErrorF("Giving up on webhook for job %s to %s after %d attempts: %v", payload.JobID, target, attempt, err)
+			return
+		}
+
+		utils.Logger.ErrorF("Webhook for job %s to %s failed (attempt %d of %d), retrying in %v: %v",
+			payload.JobID, target, attempt, webhookMaxAttempts, backoff, err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+func (wn *WebhookNotifier) post(client *http.Client, target string, payload WebhookPayload, body []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signer-Event", payload.Event)
+	req.Header.Set("X-Signer-Job", payload.JobID)
+	req.Header.Set("X-Signer-Signature", "sha256="+signature)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateCallbackURL checks that a callback URL given on upload is an
+// absolute http or https URL whose host resolves to addresses callbacks may be
+// sent to.
+func (wn *WebhookNotifier) validateCallbackURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return fmt.Errorf("missing host")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("cannot resolve %s: %v", u.Hostname(), err)
+	}
+	for _, addr := range addrs {
+		if !wn.addressAllowed(addr.IP) {
+			return fmt.Errorf("%s resolves to %s, which is not allowed", u.Hostname(), addr.IP)
+		}
+	}
+	return nil
+}
+
+// addressAllowed reports whether callbacks may be sent to ip. Callback URLs
+// come from whoever uploads a file, so loopback, private and link-local
+// addresses, which include cloud metadata endpoints such as 169.254.169.254,
+// are refused unless their network is in webhooks.allowed_networks.
+func (wn *WebhookNotifier) addressAllowed(ip net.IP) bool {
+	for _, network := range wn.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/YHVCorp/signer-service/server/config"
+)
+
+type receivedWebhook struct {
+	signature string
+	body      []byte
+}
+
+func TestWebhookSignedWithTokenSecret(t *testing.T) {
+	received := make(chan receivedWebhook, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{signature: r.Header.Get("X-Signer-Signature"), body: body}
+	}))
+	defer receiver.Close()
+
+	token, err := config.GenerateConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(config.GetConfigPath()) })
+	wn := NewWebhookNotifier()
+	// The receiver listens on loopback
+	wn.allowed = []*net.IPNet{{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}}
+	payload := WebhookPayload{Event: webhookEventJobFinished, JobID: "job", Status: StatusReady}
+
+	// Webhooks are never sent unsigned
+	wn.deliver(receiver.URL+"/callback", config.DefaultTokenName, payload)
+	select {
+	case webhook := <-received:
+		t.Fatalf("webhook delivered for a token without secret: %s", webhook.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Adding the first webhook of a token generates its secret
+	secret, err := config.AddWebhook(config.DefaultTokenName, receiver.URL+"/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret == "" || secret == token {
+		t.Fatalf("webhook secret = %q, want a new secret apart from the client token", secret)
+	}
+	if again, err := config.AddWebhook(config.DefaultTokenName, receiver.URL+"/other"); err != nil || again != "" {
+		t.Fatalf("AddWebhook() = %q, %v for a token with a secret, want the secret kept", again, err)
+	}
+	config.RemoveWebhook(config.DefaultTokenName, receiver.URL+"/other")
+
+	wn.deliver(receiver.URL+"/callback", config.DefaultTokenName, payload)
+	for i := 0; i < 2; i++ {
+		webhook := waitForWebhook(t, received)
+		if want := "sha256=" + signWebhook(secret, webhook.body); webhook.signature != want {
+			t.Errorf("signature = %s, want %s", webhook.signature, want)
+		}
+		if webhook.signature == "sha256="+signWebhook(token, webhook.body) {
+			t.Error("webhook is signed with the client token")
+		}
+
+		var got WebhookPayload
+		if err := json.Unmarshal(webhook.body, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.JobID != "job" || got.Status != StatusReady {
+			t.Errorf("payload = %+v", got)
+		}
+	}
+
+	// Jobs of other tokens do not use the token's webhooks
+	wn.deliver("", "other", payload)
+	select {
+	case webhook := <-received:
+		t.Fatalf("webhook of another token delivered: %s", webhook.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func waitForWebhook(t *testing.T, received chan receivedWebhook) receivedWebhook {
+	t.Helper()
+	select {
+	case webhook := <-received:
+		return webhook
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+		return receivedWebhook{}
+	}
+}
+
+func TestCallbackURLRejectsInternalAddresses(t *testing.T) {
+	tests := []struct {
+		url     string
+		allowed string
+		wantErr bool
+	}{
+		{"https://93.184.216.34/hook", "", false},
+		{"http://127.0.0.1:8080/hook", "", true},
+		{"http://localhost/hook", "", true},
+		{"http://[::1]/hook", "", true},
+		{"http://10.1.2.3/hook", "", true},
+		{"http://192.168.0.10/hook", "", true},
+		{"http://169.254.169.254/latest/meta-data/", "", true},
+		{"http://[fe80::1]/hook", "", true},
+		{"http://0.0.0.0/hook", "", true},
+		{"http://10.1.2.3/hook", "10.0.0.0/8", false},
+		{"http://10.1.2.3/hook", "10.1.2.4", true},
+		{"ftp://93.184.216.34/hook", "", true},
+	}
+
+	for _, tt := range tests {
+		wn := NewWebhookNotifier()
+		if tt.allowed != "" {
+			networks, err := config.WebhooksConfig{AllowedNetworks: []string{tt.allowed}}.Networks()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wn.allowed = networks
+		}
+		if err := wn.validateCallbackURL(tt.url); (err != nil) != tt.wantErr {
+			t.Errorf("validateCallbackURL(%s) with %q allowed = %v, want error %v", tt.url, tt.allowed, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCallbackNotDeliveredToInternalAddress(t *testing.T) {
+	received := make(chan receivedWebhook, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- receivedWebhook{}
+	}))
+	defer receiver.Close()
+
+	// The host passed validation but resolves to loopback when the job
+	// finishes
+	wn := NewWebhookNotifier()
+	err := wn.post(wn.callbackClient, receiver.URL, WebhookPayload{JobID: "job"}, []byte("{}"), "signature")
+	if err == nil {
+		t.Fatal("callback posted to a loopback address")
+	}
+	select {
+	case <-received:
+		t.Fatal("callback received on a loopback address")
+	default:
+	}
+}
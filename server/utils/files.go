@@ -39,3 +39,11 @@ func IsSubPath(path string, base string) bool {
 	}
 	return !strings.HasPrefix(rel, "..") && !filepath.IsAbs(rel)
 }
+
+func FileExists(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return !info.IsDir()
+}